@@ -0,0 +1,17 @@
+# resolve the newest supported debian version below 13
+data "virtomize_distribution" "debian" {
+    distribution = "debian"
+    version_constraint = ">= 11, < 13"
+    architecture = "64"
+}
+
+resource "virtomize_iso" "debian_iso" {
+    name = "debian_iso"
+    distribution = data.virtomize_distribution.debian.distribution
+    version = data.virtomize_distribution.debian.version
+    hostname = "examplehost"
+    networks = [{
+      dhcp = true
+      no_internet = false
+  }]
+ }
@@ -0,0 +1,5 @@
+# list all supported 64 bit debian versions
+data "virtomize_operating_systems" "debian" {
+    distribution = "debian"
+    architecture = "64"
+}
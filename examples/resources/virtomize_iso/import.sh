@@ -0,0 +1,2 @@
+# ISOs that are still present in the local storage can be imported by their name
+terraform import virtomize_iso.debian_iso debian_iso
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 	"terraform-provider-uii/provider"
 
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
@@ -12,6 +13,10 @@ import (
 //go:generate go run github.com/hashicorp/terraform-plugin-docs/cmd/tfplugindocs generate --provider-name virtomize-uii
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == provider.GarbageCollectionCommand {
+		os.Exit(provider.RunGarbageCollection(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	err := providerserver.Serve(context.Background(), provider.New, providerserver.ServeOpts{
 		Address: "registry.terraform.io/Virtomize/uii",
 	})
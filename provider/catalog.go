@@ -0,0 +1,165 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	catalogTTLKey     = "catalog_ttl"
+	refreshCatalogKey = "refresh_catalog"
+
+	// the operating systems supported by UII change rarely
+	defaultCatalogTTL = 24 * time.Hour
+
+	// catalogFileName is the file in the storage folder caching the operating systems supported by UII
+	catalogFileName = "operating_systems.json"
+
+	// catalogLock serializes the fetches of the catalog, so that it is fetched once per run
+	catalogLock = "catalog:operating_systems"
+)
+
+// operatingSystemsCatalog is the cached list of the operating systems supported by UII
+type operatingSystemsCatalog struct {
+	FetchedAt        time.Time   `json:"fetched_at"`
+	OperatingSystems []client.OS `json:"operating_systems"`
+}
+
+// ReadDistributions returns the operating systems supported by UII. They are fetched once per run and cached in the
+// storage folder for CatalogTTL, so that later runs don't need to fetch them. RefreshCatalog skips the cached catalog.
+func (s *clientWithStorage) ReadDistributions(ctx context.Context) ([]client.OS, error) {
+	if s.VirtomizeClient == nil {
+		return nil, ErrClientInit
+	}
+
+	unlock, err := s.lockIsoContext(ctx, catalogLock)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if catalog := s.memoizedCatalog(); catalog != nil {
+		return catalog, nil
+	}
+
+	if !s.RefreshCatalog {
+		if catalog := s.readCatalogFile(ctx); catalog != nil {
+			s.memoizeCatalog(catalog)
+			return catalog, nil
+		}
+	}
+
+	catalog, err := s.VirtomizeClient.OperatingSystems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.writeCatalogFile(catalog); err != nil {
+		// the catalog is fetched again by the next run
+		tflog.Warn(ctx, "Could not cache the operating systems supported by UII", map[string]interface{}{"error": err.Error()})
+	}
+
+	s.memoizeCatalog(catalog)
+	return catalog, nil
+}
+
+// CachedDistributions returns the operating systems supported by UII without contacting it, so that the plan can
+// validate the configuration offline. It returns nil if no catalog was fetched by this run or is cached.
+func (s *clientWithStorage) CachedDistributions(ctx context.Context) []client.OS {
+	if catalog := s.memoizedCatalog(); catalog != nil {
+		return catalog
+	}
+
+	if s.RefreshCatalog {
+		return nil
+	}
+
+	return s.readCatalogFile(ctx)
+}
+
+func (s *clientWithStorage) memoizedCatalog() []client.OS {
+	s.catalogMutex.Lock()
+	defer s.catalogMutex.Unlock()
+	return s.catalog
+}
+
+func (s *clientWithStorage) memoizeCatalog(catalog []client.OS) {
+	s.catalogMutex.Lock()
+	defer s.catalogMutex.Unlock()
+	s.catalog = catalog
+}
+
+func (s *clientWithStorage) catalogFilePath() string {
+	return filepath.Join(s.StorageFolder, catalogFileName)
+}
+
+// catalogTTL returns how long the cached catalog is used
+func (s *clientWithStorage) catalogTTL() time.Duration {
+	if s.CatalogTTL <= 0 {
+		return defaultCatalogTTL
+	}
+
+	return s.CatalogTTL
+}
+
+// readCatalogFile returns the cached operating systems, or nil if there are none or they expired
+func (s *clientWithStorage) readCatalogFile(ctx context.Context) []client.OS {
+	if s.StorageFolder == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(s.catalogFilePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			tflog.Warn(ctx, "Could not read the cached operating systems", map[string]interface{}{"error": err.Error()})
+		}
+		return nil
+	}
+
+	var catalog operatingSystemsCatalog
+	if err := json.Unmarshal(content, &catalog); err != nil {
+		tflog.Warn(ctx, "Ignoring corrupt cache of the operating systems", map[string]interface{}{"error": err.Error()})
+		return nil
+	}
+
+	if s.now().Sub(catalog.FetchedAt) > s.catalogTTL() || len(catalog.OperatingSystems) == 0 {
+		return nil
+	}
+
+	return catalog.OperatingSystems
+}
+
+// writeCatalogFile caches the operating systems in the storage folder, the file is replaced atomically
+func (s *clientWithStorage) writeCatalogFile(operatingSystems []client.OS) error {
+	if s.StorageFolder == "" || len(operatingSystems) == 0 {
+		return nil
+	}
+
+	content, err := json.Marshal(operatingSystemsCatalog{FetchedAt: s.now(), OperatingSystems: operatingSystems})
+	if err != nil {
+		return err
+	}
+
+	tmpPath, err := tempFilePath(s.catalogFilePath())
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, s.catalogFilePath()); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCatalog = []client.OS{
+	{Architecture: "64", DisplayName: "Debian 11", Distribution: "debian", Version: "11"},
+	{Architecture: "64", DisplayName: "Ubuntu 22.04", Distribution: "ubuntu", Version: "22.04"},
+}
+
+// newTestCatalogClient returns a client listing testCatalog, the flaky client counts how often it was fetched
+func newTestCatalogClient(t *testing.T, storageFolder string) (*clientWithStorage, *flakyUiiClient) {
+	c, _ := newTestClient(t)
+	flaky := &flakyUiiClient{}
+	flaky.operatingSystems = testCatalog
+	c.VirtomizeClient = flaky
+	c.StorageFolder = storageFolder
+	return c, flaky
+}
+
+func TestCatalogIsFetchedOncePerRun(t *testing.T) {
+	c, flaky := newTestCatalogClient(t, t.TempDir())
+
+	for i := 0; i < 3; i++ {
+		distributions, err := c.ReadDistributions(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, testCatalog, distributions)
+	}
+	assert.Equal(t, 1, flaky.attempts)
+	assert.Equal(t, testCatalog, c.CachedDistributions(context.Background()))
+
+	// a failed fetch is not memoized
+	c, flaky = newTestCatalogClient(t, t.TempDir())
+	flaky.failures = 1
+	flaky.err = &uiiStatusError{StatusCode: 400}
+	_, err := c.ReadDistributions(context.Background())
+	assert.Error(t, err)
+	assert.Nil(t, c.CachedDistributions(context.Background()))
+
+	distributions, err := c.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, testCatalog, distributions)
+	assert.Equal(t, 2, flaky.attempts)
+}
+
+func TestCatalogIsCachedInTheStorageFolder(t *testing.T) {
+	storageFolder := t.TempDir()
+	c, flaky := newTestCatalogClient(t, storageFolder)
+	_, err := c.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flaky.attempts)
+	assert.FileExists(t, filepath.Join(storageFolder, catalogFileName))
+
+	// the next run validates offline and doesn't fetch the catalog again
+	next, nextFlaky := newTestCatalogClient(t, storageFolder)
+	next.TimeProvider = &fixedTimeProvider{now: time.Date(2023, 6, 1, 23, 0, 0, 0, time.UTC)}
+	assert.Equal(t, testCatalog, next.CachedDistributions(context.Background()))
+	distributions, err := next.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, testCatalog, distributions)
+	assert.Equal(t, 0, nextFlaky.attempts)
+
+	// once the TTL passed the catalog is fetched again
+	expired, expiredFlaky := newTestCatalogClient(t, storageFolder)
+	expired.TimeProvider = &fixedTimeProvider{now: time.Date(2023, 6, 2, 13, 0, 0, 0, time.UTC)}
+	assert.Nil(t, expired.CachedDistributions(context.Background()))
+	_, err = expired.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expiredFlaky.attempts)
+
+	// a shorter TTL expires it earlier
+	short, shortFlaky := newTestCatalogClient(t, storageFolder)
+	short.TimeProvider = &fixedTimeProvider{now: time.Date(2023, 6, 2, 15, 0, 0, 0, time.UTC)}
+	short.CatalogTTL = time.Hour
+	assert.Nil(t, short.CachedDistributions(context.Background()))
+	_, err = short.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, shortFlaky.attempts)
+}
+
+func TestRefreshCatalogSkipsTheCache(t *testing.T) {
+	storageFolder := t.TempDir()
+	c, _ := newTestCatalogClient(t, storageFolder)
+	_, err := c.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+
+	refresh, flaky := newTestCatalogClient(t, storageFolder)
+	refresh.RefreshCatalog = true
+	flaky.operatingSystems = append(testCatalog, client.OS{Architecture: "64", DisplayName: "Debian 12", Distribution: "debian", Version: "12"})
+	assert.Nil(t, refresh.CachedDistributions(context.Background()))
+
+	// the refresh happens once per run and replaces the cache
+	for i := 0; i < 2; i++ {
+		distributions, err := refresh.ReadDistributions(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, distributions, 3)
+	}
+	assert.Equal(t, 1, flaky.attempts)
+
+	next, _ := newTestCatalogClient(t, storageFolder)
+	assert.Len(t, next.CachedDistributions(context.Background()), 3)
+}
+
+func TestCorruptCatalogIsIgnored(t *testing.T) {
+	storageFolder := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(storageFolder, catalogFileName), []byte("{"), 0600))
+
+	c, flaky := newTestCatalogClient(t, storageFolder)
+	assert.Nil(t, c.CachedDistributions(context.Background()))
+	distributions, err := c.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, testCatalog, distributions)
+	assert.Equal(t, 1, flaky.attempts)
+}
+
+func TestPlannedDistributionIsValidated(t *testing.T) {
+	plan := fullIsoModel()
+	assert.Empty(t, validatePlannedDistribution(plan, testCatalog))
+
+	// without a cached catalog everything is allowed
+	plan.Distribution = types.StringValue("gentoo")
+	assert.Empty(t, validatePlannedDistribution(plan, nil))
+
+	diags := validatePlannedDistribution(plan, testCatalog)
+	assert.True(t, diags.HasError())
+	assert.Equal(t, "Unsupported operating system", diags[0].Summary())
+
+	plan = fullIsoModel()
+	plan.Version = types.StringValue("9")
+	assert.True(t, validatePlannedDistribution(plan, testCatalog).HasError())
+
+	plan = fullIsoModel()
+	plan.Architecture = types.StringValue("32")
+	diags = validatePlannedDistribution(plan, testCatalog)
+	assert.True(t, diags.HasError())
+	assert.Equal(t, path.Root(architectureKey), diags[0].(diag.DiagnosticWithPath).Path())
+
+	// unknown values are validated once they are known
+	plan.Architecture = types.StringUnknown()
+	assert.Empty(t, validatePlannedDistribution(plan, testCatalog))
+}
+
+func TestPlanValidatesOnlyNewOperatingSystems(t *testing.T) {
+	c, flaky := newTestCatalogClient(t, t.TempDir())
+	_, err := c.CreateIso(context.Background(), parseIsoFromResourceModel(fullIsoModel()))
+	assert.NoError(t, err)
+	state, diags := importTestIso(t, c, "debian_iso")
+	assert.False(t, diags.HasError(), diags)
+
+	// UII dropped the operating system of the ISO
+	flaky.operatingSystems = testCatalog[1:]
+	_, err = c.ReadDistributions(context.Background())
+	assert.NoError(t, err)
+
+	// a new ISO is rejected
+	resp, _ := planTestIso(t, c, nil, fullIsoModel())
+	assert.True(t, resp.Diagnostics.HasError())
+	assert.Len(t, resp.Diagnostics.Errors(), 1)
+	assert.Equal(t, "Unsupported operating system", resp.Diagnostics.Errors()[0].Summary())
+
+	// the existing ISO keeps working as long as it is not rebuilt
+	plan := state
+	plan.Password = types.StringValue("secret")
+	resp, _ = planTestIso(t, c, &state, plan)
+	assert.False(t, resp.Diagnostics.HasError(), resp.Diagnostics)
+
+	plan.Hostname = types.StringValue("otherhost")
+	resp, _ = planTestIso(t, c, &state, plan)
+	assert.True(t, resp.Diagnostics.HasError())
+	assert.Empty(t, resp.Diagnostics.Warnings())
+}
@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"crypto/md5" //nolint: gosec // published for tools that only support md5, not used for security
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	verifyChecksumsKey = "verify_checksums"
+	checksumFileKey    = "checksum_file"
+
+	// verifyChecksumsFull hashes the ISO file on every read
+	verifyChecksumsFull = "full"
+	// verifyChecksumsQuick only compares the size and modification time of the ISO file
+	verifyChecksumsQuick = "quick"
+	// verifyChecksumsOff trusts the ISO file
+	verifyChecksumsOff = "off"
+
+	defaultVerifyChecksums = verifyChecksumsQuick
+
+	// checksumFileSuffix is appended to the path of the ISO for the sidecar file in the format of sha256sum
+	checksumFileSuffix = ".sha256"
+)
+
+var ErrIsoFileCorrupt = errors.New("iso file does not match its checksum")
+
+// fileChecksums hashes the file and records its size and modification time
+func fileChecksums(filePath string) (IsoChecksums, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return IsoChecksums{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return IsoChecksums{}, err
+	}
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New() //nolint: gosec // see import
+	size, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), file)
+	if err != nil {
+		return IsoChecksums{}, err
+	}
+
+	return IsoChecksums{
+		SHA256:    hex.EncodeToString(sha256Hash.Sum(nil)),
+		MD5:       hex.EncodeToString(md5Hash.Sum(nil)),
+		SizeBytes: size,
+		ModTime:   info.ModTime(),
+	}, nil
+}
+
+// VerifyIsoFile checks that the ISO file still matches the checksums recorded after its build. Depending on
+// VerifyChecksums the file is hashed again or only its size and modification time are compared. ISOs stored before
+// checksums existed are not verified.
+func (s *clientWithStorage) VerifyIsoFile(iso StoredIso) error {
+	mode := s.VerifyChecksums
+	if mode == "" {
+		mode = defaultVerifyChecksums
+	}
+
+	if mode == verifyChecksumsOff || iso.Checksums.SHA256 == "" || iso.Evicted {
+		return nil
+	}
+
+	info, err := os.Stat(iso.LocalPath)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	if info.Size() != iso.Checksums.SizeBytes {
+		return fmt.Errorf("%w: %s has %d bytes instead of %d", ErrIsoFileCorrupt, iso.LocalPath, info.Size(), iso.Checksums.SizeBytes)
+	}
+
+	if mode == verifyChecksumsQuick {
+		if !info.ModTime().Equal(iso.Checksums.ModTime) {
+			return fmt.Errorf("%w: %s was modified at %s", ErrIsoFileCorrupt, iso.LocalPath, info.ModTime())
+		}
+
+		return nil
+	}
+
+	checksums, err := fileChecksums(iso.LocalPath)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	if checksums.SHA256 != iso.Checksums.SHA256 {
+		return fmt.Errorf("%w: %s has the sha256 %s instead of %s", ErrIsoFileCorrupt, iso.LocalPath, checksums.SHA256, iso.Checksums.SHA256)
+	}
+
+	return nil
+}
+
+// writeChecksumFile writes the sha256 of the ISO next to its file if the sidecar files are enabled
+func (s *clientWithStorage) writeChecksumFile(iso StoredIso) error {
+	if !s.ChecksumFile || iso.Checksums.SHA256 == "" {
+		return nil
+	}
+
+	filePath := iso.LocalPath + checksumFileSuffix
+	tmpPath, err := tempFilePath(filePath)
+	if err == nil {
+		content := fmt.Sprintf("%s  %s\n", iso.Checksums.SHA256, filepath.Base(iso.LocalPath))
+		err = os.WriteFile(tmpPath, []byte(content), 0600)
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: could not write %s: %s", ErrStorage, filePath, err.Error())
+	}
+
+	return nil
+}
+
+// removeChecksumFile removes the sidecar file of the ISO file, it might not exist
+func removeChecksumFile(localPath string) {
+	_ = os.Remove(localPath + checksumFileSuffix)
+}
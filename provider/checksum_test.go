@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"crypto/md5" //nolint: gosec // expected checksum
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumsAreComputedAfterBuild(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	content := []byte("iso for examplehost")
+	sha256Sum := sha256.Sum256(content)
+	md5Sum := md5.Sum(content) //nolint: gosec // expected checksum
+	assert.Equal(t, hex.EncodeToString(sha256Sum[:]), stored.Checksums.SHA256)
+	assert.Equal(t, hex.EncodeToString(md5Sum[:]), stored.Checksums.MD5)
+	assert.Equal(t, int64(len(content)), stored.Checksums.SizeBytes)
+
+	// an ISO sharing the file gets the same checksums
+	other, err := c.CreateIso(context.Background(), testIso("other_iso"))
+	assert.NoError(t, err)
+	assert.Equal(t, stored.Checksums, other.Checksums)
+}
+
+func TestVerifyIsoFile(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	for _, mode := range []string{verifyChecksumsFull, verifyChecksumsQuick, verifyChecksumsOff} {
+		c.VerifyChecksums = mode
+		assert.NoError(t, c.VerifyIsoFile(stored), mode)
+	}
+
+	// the same size with the modification time restored is only found by hashing
+	assert.NoError(t, os.WriteFile(stored.LocalPath, []byte("iso for otherhost!!"), 0600))
+	assert.NoError(t, os.Chtimes(stored.LocalPath, stored.Checksums.ModTime, stored.Checksums.ModTime))
+
+	c.VerifyChecksums = verifyChecksumsQuick
+	assert.NoError(t, c.VerifyIsoFile(stored))
+	c.VerifyChecksums = verifyChecksumsFull
+	assert.ErrorIs(t, c.VerifyIsoFile(stored), ErrIsoFileCorrupt)
+
+	// a touched file is found by the quick verification
+	assert.NoError(t, os.Chtimes(stored.LocalPath, time.Now(), time.Now()))
+	c.VerifyChecksums = verifyChecksumsQuick
+	assert.ErrorIs(t, c.VerifyIsoFile(stored), ErrIsoFileCorrupt)
+
+	c.VerifyChecksums = verifyChecksumsOff
+	assert.NoError(t, c.VerifyIsoFile(stored))
+
+	// ISOs stored before checksums existed are not verified
+	c.VerifyChecksums = verifyChecksumsFull
+	stored.Checksums = IsoChecksums{}
+	assert.NoError(t, c.VerifyIsoFile(stored))
+}
+
+func TestReadIsoHashesIsosStoredWithoutChecksums(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	store, err := c.openStore()
+	assert.NoError(t, err)
+	legacy := stored
+	legacy.Checksums = IsoChecksums{}
+	assert.NoError(t, store.WriteIso(context.Background(), legacy))
+
+	read, err := c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.Checksums.SHA256, read.Checksums.SHA256)
+
+	record, err := store.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.Checksums.SHA256, record.Checksums.SHA256)
+}
+
+func TestChecksumFile(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.ChecksumFile = true
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	checksumFile := stored.LocalPath + checksumFileSuffix
+	assert.Equal(t, stored.Checksums.SHA256+"  debian_iso.iso\n", string(readFile(t, checksumFile)))
+
+	// orphaned checksum files are garbage
+	orphaned := path.Join(c.StorageFolder, "died.iso"+checksumFileSuffix)
+	assert.NoError(t, os.WriteFile(orphaned, []byte("0000  died.iso\n"), 0600))
+	c.TimeProvider = &fixedTimeProvider{now: time.Now()}
+	ageFiles(t, orphaned)
+	report, err := c.CollectGarbage(true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{orphaned}, report.OrphanedFiles)
+
+	assert.NoError(t, c.DeleteIso(context.Background(), stored.ID))
+	assert.NoFileExists(t, checksumFile)
+}
@@ -1,30 +1,61 @@
 package provider
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
-	"path"
+	"path/filepath"
+	"sync"
 	"time"
 
 	client "github.com/Virtomize/uii-go-api"
-	"github.com/boltdb/bolt"
 )
 
 var (
 	ErrBucketNotFound    = errors.New("bucket not found")
 	ErrStoragePathNotSet = errors.New("storage path not set")
 	ErrClientInit        = errors.New("client not initialised")
+	ErrIsoNotFound       = errors.New("iso not found")
+	ErrStorage           = errors.New("could not access iso storage")
+	ErrBuildFailed       = errors.New("uii could not build the iso")
+	ErrDownloadFailed    = errors.New("iso could not be downloaded")
+	ErrStorageLocked     = errors.New("cache locked by another process")
+	ErrStorageCorrupt    = errors.New("iso storage is corrupt")
+	ErrStorageVersion    = errors.New("iso storage was written by a newer version of the provider")
 
 	DataBaseName = "uii.db"
 )
 
+const (
+	// missingFileRebuild rebuilds a missing ISO file during Read
+	missingFileRebuild = "rebuild"
+	// missingFileRecreate removes a resource with a missing ISO file from the state, so that the next apply recreates it
+	missingFileRecreate = "recreate"
+)
+
+const (
+	// rebuildOnRead rebuilds expired ISOs during refresh
+	rebuildOnRead = "on_read"
+	// rebuildOnApply plans an update for expired ISOs, which rebuilds them during apply
+	rebuildOnApply = "on_apply"
+	// rebuildNever never lets ISOs expire
+	rebuildNever = "never"
+
+	defaultRebuildAfter  = 48 * time.Hour
+	defaultRebuildPolicy = rebuildOnApply
+
+	// defaultLockTimeout is the time to wait for another process to release the database
+	defaultLockTimeout = 10 * time.Second
+)
+
 // IUiiClient is an interface for abstracting the interactions with the UII service - used for testing
 type IUiiClient interface {
-	Build(filePath string, args client.BuildArgs, opts client.BuildOpts) error
-	OperatingSystems() ([]client.OS, error)
+	Build(ctx context.Context, filePath string, args client.BuildArgs, opts client.BuildOpts) error
+	OperatingSystems(ctx context.Context) ([]client.OS, error)
 }
 
 // ITimeProvider is an interface for injecting custom time providers - used for testing
@@ -33,9 +64,52 @@ type ITimeProvider interface {
 }
 
 type clientWithStorage struct {
-	VirtomizeClient IUiiClient
-	StorageFolder   string
-	TimeProvider    ITimeProvider
+	VirtomizeClient   IUiiClient
+	StorageFolder     string
+	TimeProvider      ITimeProvider
+	MissingFilePolicy string
+	RebuildAfter      time.Duration
+	RebuildPolicy     string
+	LockTimeout       time.Duration
+	StorageBackend    string
+	S3                *S3Config
+	// EncryptionKey encrypts the records in the store, PreviousEncryptionKeys only decrypt them during a key rotation
+	EncryptionKey          string
+	PreviousEncryptionKeys []string
+	// MaxCacheSize and MaxCacheAge limit the ISO files in the storage folder, zero disables the limit
+	MaxCacheSize int64
+	MaxCacheAge  time.Duration
+	// VerifyChecksums selects how ISO files are verified on read, ChecksumFile writes a .sha256 file next to them
+	VerifyChecksums string
+	ChecksumFile    bool
+	// MaxConcurrentBuilds limits the builds running at the same time, zero disables the limit. The rate limit is
+	// applied by wrapping VirtomizeClient in a limitedUiiClient.
+	MaxConcurrentBuilds int
+	// CatalogTTL is how long the operating systems supported by UII are cached, RefreshCatalog fetches them anyway
+	CatalogTTL     time.Duration
+	RefreshCatalog bool
+
+	// the store is opened once and shared by all operations
+	storeMutex sync.Mutex
+	store      Store
+
+	// isoLocks holds a lock channel per ISO id, so that operations on the same ISO don't interleave
+	isoLocks sync.Map
+
+	// evictionMutex serializes the eviction passes, liveFiles holds the cache keys of the ISOs used by this run
+	evictionMutex sync.Mutex
+	liveFiles     sync.Map
+
+	// blobChecksums holds the checksums of the blobs built or verified by this run, so that they are hashed once
+	blobChecksums sync.Map
+
+	// the limiter is created once and shared by all operations, it might be shared with the limitedUiiClient
+	limiterOnce sync.Once
+	limiter     *uiiLimiter
+
+	// catalog holds the operating systems supported by UII once they were read by this run
+	catalogMutex sync.Mutex
+	catalog      []client.OS
 }
 
 // defaultTimeProvider is an implementation of ITimeProvider using local time
@@ -47,210 +121,415 @@ func (p defaultTimeProvider) Now() time.Time {
 	return time.Now()
 }
 
-// CreateIso creates a new iso resource
-func (s *clientWithStorage) CreateIso(iso Iso) (StoredIso, error) {
-	if s.StorageFolder == "" {
-		log.Fatal(ErrStoragePathNotSet)
-		return StoredIso{}, ErrStoragePathNotSet
+// now returns the current time of the TimeProvider, or the local time if none is set
+func (s *clientWithStorage) now() time.Time {
+	if s.TimeProvider == nil {
+		return time.Now()
 	}
 
-	db, err := setupDB(path.Join(s.StorageFolder, DataBaseName))
+	return s.TimeProvider.Now()
+}
+
+// CreateIso creates a new iso resource
+func (s *clientWithStorage) CreateIso(ctx context.Context, iso Iso) (StoredIso, error) {
+	store, err := s.openStore()
 	if err != nil {
-		log.Fatal(err)
 		return StoredIso{}, err
 	}
-	defer db.Close()
 
-	localPath, err := s.createIsoFileWithUii(iso)
+	// the eviction runs after the ISO is unlocked
+	defer s.evictAfterUse()
+	unlock, err := s.lockIsoContext(ctx, iso.Name)
 	if err != nil {
 		return StoredIso{}, err
 	}
+	defer unlock()
+
+	return s.createIso(ctx, store, iso)
+}
 
-	creationTime := time.Now()
-	if s.TimeProvider != nil {
-		creationTime = s.TimeProvider.Now()
+func (s *clientWithStorage) createIso(ctx context.Context, store Store, iso Iso) (StoredIso, error) {
+	blobKey := isoFingerprint(iso)
+	localPath, checksums, err := s.acquireBlob(ctx, store, iso, blobKey, buildMissingBlob, true)
+	if err != nil {
+		return StoredIso{}, err
 	}
 
-	id, err := addIso(db, StoredIso{
+	creationTime := s.now()
+
+	stored, err := store.SaveIsoFile(ctx, StoredIso{
 		ID:           iso.Name,
 		Iso:          iso,
 		LocalPath:    localPath,
 		CreationTime: creationTime,
+		Fingerprint:  blobKey,
+		BlobKey:      blobKey,
+		AccessTime:   creationTime,
+		Checksums:    checksums,
 	})
+	if err == nil {
+		err = s.writeChecksumFile(stored)
+	}
+
+	if err == nil {
+		err = store.WriteIso(ctx, stored)
+	}
+
 	if err != nil {
+		_ = os.Remove(localPath)
+		removeChecksumFile(localPath)
+		_ = s.releaseBlob(store, blobKey)
 		return StoredIso{}, err
 	}
 
-	return readIso(db, id)
+	return store.ReadIso(ctx, iso.Name)
 }
 
-// ReadIso reads a ISO resource
-func (s *clientWithStorage) ReadIso(isoID string) (StoredIso, error) {
-	db, err := setupDB(path.Join(s.StorageFolder, DataBaseName))
+// ReadIso reads a ISO resource, presigned URLs that expire soon are renewed
+func (s *clientWithStorage) ReadIso(ctx context.Context, isoID string) (StoredIso, error) {
+	store, err := s.openStore()
 	if err != nil {
-		log.Fatal(err)
 		return StoredIso{}, err
 	}
-	defer db.Close()
 
-	iso, err := readIso(db, isoID)
+	defer s.evictAfterUse()
+	unlock, err := s.lockIsoContext(ctx, isoID)
 	if err != nil {
 		return StoredIso{}, err
 	}
+	defer unlock()
 
-	if s.isExpired(iso) {
-		err = s.refreshIso(isoID, db)
+	iso, err := store.ReadIso(ctx, isoID)
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	s.markLive(cacheKey(iso))
+
+	if s.IsExpired(iso) && s.rebuildPolicy(iso) == rebuildOnRead {
+		err = s.refreshIso(ctx, store, iso, buildBlob)
 		if err != nil {
 			return StoredIso{}, err
 		}
+
+		return store.ReadIso(ctx, isoID)
+	}
+
+	if iso.Evicted {
+		// rebuilt by the next apply, so that a plan never downloads ISOs
+		return iso, nil
+	}
+
+	// the access time is only a hint for the eviction, failing to update it does not fail the read
+	iso.AccessTime = s.now()
+	_ = store.TouchIso(isoID, iso.AccessTime)
+
+	iso, err = store.RenewIsoURLs(ctx, iso)
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	if iso.Checksums.SHA256 == "" {
+		// ISOs stored before checksums existed are hashed once, a missing file is handled by the caller
+		if checksums, err := fileChecksums(iso.LocalPath); err == nil {
+			iso.Checksums = checksums
+			if err := store.WriteIso(ctx, iso); err != nil {
+				return StoredIso{}, err
+			}
+		}
 	}
 
-	return iso, err
+	return iso, nil
 }
 
-func (s *clientWithStorage) ReadDistributions() ([]client.OS, error) {
-	if s.VirtomizeClient != nil {
-		return s.VirtomizeClient.OperatingSystems()
+// LookupIso returns the record of the ISO as stored, without rebuilding evicted or expired ISOs and without touching
+// it. Imports use it, so that they never start a build.
+func (s *clientWithStorage) LookupIso(ctx context.Context, isoID string) (StoredIso, error) {
+	store, err := s.openStore()
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	unlock, err := s.lockIsoContext(ctx, isoID)
+	if err != nil {
+		return StoredIso{}, err
+	}
+	defer unlock()
+
+	return store.ReadIso(ctx, isoID)
+}
+
+// RebuildIso recreates the missing ISO file of an existing ISO resource. The ISO is only built again if no other
+// resource with the same build inputs still holds the file.
+func (s *clientWithStorage) RebuildIso(ctx context.Context, isoID string) (StoredIso, error) {
+	store, err := s.openStore()
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	defer s.evictAfterUse()
+	unlock, err := s.lockIsoContext(ctx, isoID)
+	if err != nil {
+		return StoredIso{}, err
+	}
+	defer unlock()
+
+	iso, err := store.ReadIso(ctx, isoID)
+	if err != nil {
+		return StoredIso{}, err
 	}
 
-	return nil, ErrClientInit
+	err = s.refreshIso(ctx, store, iso, buildMissingBlob)
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	return store.ReadIso(ctx, isoID)
 }
 
-// DeleteIso reads a ISO resource
-func (s *clientWithStorage) DeleteIso(isoID string) error {
-	db, err := setupDB(path.Join(s.StorageFolder, DataBaseName))
+// RepairIso rebuilds the corrupt ISO file of an existing ISO resource with UII. Other resources linking to the same
+// file keep it until they are repaired themselves, which links them to the repaired blob without building it again.
+func (s *clientWithStorage) RepairIso(ctx context.Context, isoID string) (StoredIso, error) {
+	store, err := s.openStore()
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	defer s.evictAfterUse()
+	unlock, err := s.lockIsoContext(ctx, isoID)
+	if err != nil {
+		return StoredIso{}, err
+	}
+	defer unlock()
+
+	iso, err := store.ReadIso(ctx, isoID)
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	err = s.refreshIso(ctx, store, iso, buildCorruptBlob)
+	if err != nil {
+		return StoredIso{}, err
+	}
+
+	return store.ReadIso(ctx, isoID)
+}
+
+// IsoFileExists checks if the ISO file of a stored ISO is still present
+func (s *clientWithStorage) IsoFileExists(ctx context.Context, iso StoredIso) (bool, error) {
+	store, err := s.openStore()
+	if err != nil {
+		return false, err
+	}
+
+	return store.IsoFileExists(ctx, iso)
+}
+
+// DeleteIso deletes the record of the ISO and releases its file
+func (s *clientWithStorage) DeleteIso(ctx context.Context, isoID string) error {
+	store, err := s.openStore()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockIsoContext(ctx, isoID)
 	if err != nil {
-		log.Panic(err)
 		return err
 	}
-	defer db.Close()
+	defer unlock()
+
+	oldIso, err := store.ReadIso(ctx, isoID)
+	if errors.Is(err, ErrIsoNotFound) {
+		// already gone
+		return nil
+	}
 
-	oldIso, err := readIso(db, isoID)
 	if err != nil {
-		log.Panic(err)
 		return err
 	}
-	_ = os.Remove(oldIso.LocalPath)
-	return deleteIso(db, isoID)
+	_ = store.DeleteIsoFile(ctx, oldIso)
+	removeChecksumFile(oldIso.LocalPath)
+
+	err = store.DeleteIso(ctx, isoID)
+	if err != nil {
+		return err
+	}
+
+	return s.releaseBlob(store, oldIso.BlobKey)
 }
 
-// UpdateIso updates a ISO resource
-func (s *clientWithStorage) UpdateIso(id string, iso Iso) error {
-	db, err := setupDB(path.Join(s.StorageFolder, DataBaseName))
+// UpdateIso updates a ISO resource. The ISO file is only rebuilt if the build inputs changed or a rebuild is forced.
+func (s *clientWithStorage) UpdateIso(ctx context.Context, id string, iso Iso, forceRebuild bool) error {
+	store, err := s.openStore()
 	if err != nil {
-		log.Panic(err)
 		return err
 	}
-	defer db.Close()
 
-	oldIso, err := readIso(db, id)
+	defer s.evictAfterUse()
+	unlock, err := s.lockIsoContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	oldIso, err := store.ReadIso(ctx, id)
+	if err != nil && !errors.Is(err, ErrIsoNotFound) {
+		return err
+	}
 
 	if err != nil {
-		// error reading -> might be gone. Write a new one
-		oldIso, err = s.CreateIso(iso)
+		// might be gone. Write a new one
+		oldIso, err = s.createIso(ctx, store, iso)
 		if err != nil {
-			log.Panic(err)
 			return err
 		}
 	}
 
-	if requiresNewIsoFile(iso, oldIso) {
-		// refresh iso and re-read, as path potentially updated
-		err = s.refreshIso(id, db)
-		if err != nil {
-			log.Panic(err)
-			return err
+	s.markLive(cacheKey(oldIso))
+	rebuild := forceRebuild || requiresNewIsoFile(iso, oldIso)
+
+	updated := oldIso
+	updated.ID = id
+	updated.Iso = iso
+	if rebuild || oldIso.Evicted {
+		// the new inputs are only stored once their file is in place, a failed build keeps the previous record.
+		// Evicted files are rebuilt lazily, other ISOs might hold the file already.
+		build := buildMissingBlob
+		if rebuild {
+			build = buildBlob
 		}
 
-		oldIso, err = readIso(db, id)
-		if err != nil {
-			log.Panic(err)
-			return err
-		}
+		return s.refreshIso(ctx, store, updated, build)
 	}
 
-	return updateIso(db, id, StoredIso{id, iso, oldIso.LocalPath, time.Now()})
+	// everything describing the ISO file is kept
+	updated.AccessTime = s.now()
+	return store.WriteIso(ctx, updated)
 }
 
-func setupDB(dbPath string) (*bolt.DB, error) {
-	db, err := bolt.Open(dbPath, 0600, nil)
-	if err != nil {
-		return nil, fmt.Errorf("could not open db, %w", err)
+// openStore returns the store of the ISOs. It is opened on first use and kept open until Close is called.
+func (s *clientWithStorage) openStore() (Store, error) {
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+
+	if s.store != nil {
+		return s.store, nil
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		root, err := tx.CreateBucketIfNotExists([]byte("DB"))
-		if err != nil {
-			return fmt.Errorf("could not create root bucket: %w", err)
-		}
-		_, err = root.CreateBucketIfNotExists([]byte("ISOS"))
-		if err != nil {
-			return fmt.Errorf("could not create weight bucket: %w", err)
-		}
 
-		return nil
+	store, err := newStore(StorageConfig{
+		Backend:                s.StorageBackend,
+		Folder:                 s.StorageFolder,
+		LockTimeout:            s.LockTimeout,
+		S3:                     s.S3,
+		EncryptionKey:          s.EncryptionKey,
+		PreviousEncryptionKeys: s.PreviousEncryptionKeys,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not set up buckets, %w", err)
+		return nil, err
 	}
 
-	return db, nil
+	s.store = store
+	return store, nil
 }
 
-func addIso(db *bolt.DB, iso StoredIso) (string, error) {
-	err := updateIso(db, iso.Name, iso)
-	return iso.Name, err
+// requestLimiter returns the limiter of the requests to UII
+func (s *clientWithStorage) requestLimiter() *uiiLimiter {
+	s.limiterOnce.Do(func() {
+		if s.limiter == nil {
+			s.limiter = newUiiLimiter(s.MaxConcurrentBuilds, 0)
+		}
+	})
+
+	return s.limiter
 }
 
-func updateIso(db *bolt.DB, isoKey string, iso StoredIso) error {
-	entryBytes, err := json.Marshal(StoredIso{
-		ID:           isoKey,
-		Iso:          iso.Iso,
-		LocalPath:    iso.LocalPath,
-		CreationTime: iso.CreationTime,
-	})
-	if err != nil {
-		return fmt.Errorf("could marshal iso: %w", err)
-	}
+// Close releases the store, so that other processes can use the storage folder
+func (s *clientWithStorage) Close() error {
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		err := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS")).Put([]byte(isoKey), entryBytes)
-		if err != nil {
-			return fmt.Errorf("could not insert iso: %w", err)
-		}
+	if s.store == nil {
 		return nil
-	})
+	}
 
+	err := s.store.Close()
+	s.store = nil
 	return err
 }
 
-func readIso(db *bolt.DB, isoKey string) (isoData StoredIso, err error) {
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS"))
-		if b == nil {
-			return ErrBucketNotFound
-		}
-		rawData := b.Get([]byte(isoKey))
-		marshalErr := json.Unmarshal(rawData, &isoData)
-		return marshalErr
-	})
-	return isoData, err
+// blobLockPrefix separates the locks of blobs from the locks of ISOs
+const blobLockPrefix = "blob:"
+
+// blobBuild selects when acquireBlob builds the blob with UII
+type blobBuild int
+
+const (
+	// buildMissingBlob only builds blobs that don't exist yet
+	buildMissingBlob blobBuild = iota
+	// buildBlob always builds the blob, for example for expired ISOs
+	buildBlob
+	// buildCorruptBlob builds the blob if it is still the corrupt file of the ISO. Another ISO linking to the same file
+	// might have repaired the blob already.
+	buildCorruptBlob
+)
+
+// lockIso locks the ISO with the given id and returns the function to unlock it
+func (s *clientWithStorage) lockIso(isoID string) func() {
+	// can't fail without a deadline
+	unlock, _ := s.lockIsoContext(context.Background(), isoID)
+	return unlock
 }
 
-func deleteIso(db *bolt.DB, isoKey string) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS"))
-		if b == nil {
-			return ErrBucketNotFound
-		}
-		return b.Delete([]byte(isoKey))
-	})
+// lockIsoContext locks the ISO with the given id like lockIso, but stops waiting for the lock once the context is done
+func (s *clientWithStorage) lockIsoContext(ctx context.Context, isoID string) (func(), error) {
+	lock, _ := s.isoLocks.LoadOrStore(isoID, make(chan struct{}, 1))
+	held, _ := lock.(chan struct{})
+
+	select {
+	case held <- struct{}{}:
+		return func() { <-held }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for %s: %w", isoID, ctx.Err())
+	}
 }
 
-func requiresNewIsoFile(_ Iso, _ StoredIso) bool {
-	return true
+// requiresNewIsoFile checks if the build inputs differ from the ones the stored ISO file was built with
+func requiresNewIsoFile(iso Iso, storedIso StoredIso) bool {
+	return isoFingerprint(iso) != storedIso.Fingerprint
 }
 
-func (s *clientWithStorage) createIsoFileWithUii(iso Iso) (string, error) {
+// isoFingerprint computes a canonical hash of everything that affects the content of the ISO file
+func isoFingerprint(iso Iso) string {
+	args, opts := buildRequest(iso)
+
+	// unset and empty lists produce the same ISO
+	for i := range args.Networks {
+		if args.Networks[i].DNS == nil {
+			args.Networks[i].DNS = []string{}
+		}
+	}
+
+	if opts.SSHKeys == nil {
+		opts.SSHKeys = []string{}
+	}
+
+	if opts.Packages == nil {
+		opts.Packages = []string{}
+	}
+
+	// marshalling structs is deterministic, as fields are written in declaration order.
+	// It can't fail for plain strings, bools and lists.
+	canonical, _ := json.Marshal(struct {
+		Args client.BuildArgs `json:"args"`
+		Opts client.BuildOpts `json:"opts"`
+	}{args, opts})
+
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:])
+}
+
+// buildRequest creates the arguments for the UII build request
+func buildRequest(iso Iso) (client.BuildArgs, client.BuildOpts) {
 	networks := []client.NetworkArgs{}
 	for _, net := range iso.Networks {
 		networks = append(networks, client.NetworkArgs{
@@ -264,8 +543,7 @@ func (s *clientWithStorage) createIsoFileWithUii(iso Iso) (string, error) {
 		})
 	}
 
-	localPath := path.Join(s.StorageFolder, iso.Name+".iso")
-	err := s.VirtomizeClient.Build(localPath, client.BuildArgs{
+	return client.BuildArgs{
 		Distribution: iso.Distribution,
 		Version:      iso.Version,
 		Hostname:     iso.HostName,
@@ -279,29 +557,305 @@ func (s *clientWithStorage) createIsoFileWithUii(iso Iso) (string, error) {
 		Timezone:        iso.Optionals.Timezone,
 		Arch:            iso.Optionals.Arch,
 		Packages:        iso.Optionals.Packages,
-	})
-	return localPath, err
+	}
+}
+
+// acquireBlob links the ISO file of the resource to the blob with the build inputs of the ISO and optionally adds a
+// reference to the blob. The blob is built with UII if it does not exist yet or as selected by build. It returns the
+// path of the linked file and the checksums of the blob.
+func (s *clientWithStorage) acquireBlob(ctx context.Context, store Store, iso Iso, blobKey string, build blobBuild, addReference bool) (string, IsoChecksums, error) {
+	unlock, err := s.lockIsoContext(ctx, blobLockPrefix+blobKey)
+	if err != nil {
+		return "", IsoChecksums{}, err
+	}
+	defer unlock()
+	s.markLive(blobKey)
+
+	blobPath := store.BlobPath(blobKey)
+	localPath := store.IsoFilePath(iso.Name)
+	var checksums IsoChecksums
+	info, err := os.Stat(blobPath)
+	if err != nil || build == buildBlob || (build == buildCorruptBlob && isSameFile(info, localPath)) {
+		checksums, err = s.createIsoFileWithUii(ctx, iso, blobPath)
+		if err != nil {
+			return "", IsoChecksums{}, err
+		}
+		s.blobChecksums.Store(blobKey, checksums)
+	} else {
+		checksums, err = s.knownBlobChecksums(store, blobKey, blobPath, info)
+		if err != nil {
+			return "", IsoChecksums{}, err
+		}
+	}
+
+	err = linkFile(blobPath, localPath)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return "", IsoChecksums{}, fmt.Errorf("%w to %s: %s", ErrDownloadFailed, localPath, err.Error())
+	}
+
+	if addReference {
+		_, err = store.ReferenceBlob(blobKey, 1)
+	}
+
+	return localPath, checksums, err
+}
+
+// isSameFile checks if the file at filePath is the file described by info, i.e. a hard link to it
+func isSameFile(info os.FileInfo, filePath string) bool {
+	other, err := os.Stat(filePath)
+	return err == nil && os.SameFile(info, other)
+}
+
+// knownBlobChecksums returns the checksums of an existing blob without hashing it. They are taken from this run or
+// from a record referencing the blob, as long as size and modification time of the blob still match. Only blobs
+// without known checksums, like blobs of older provider versions, are hashed.
+func (s *clientWithStorage) knownBlobChecksums(store Store, blobKey, blobPath string, info os.FileInfo) (IsoChecksums, error) {
+	matches := func(checksums IsoChecksums) bool {
+		return checksums.SHA256 != "" && checksums.SizeBytes == info.Size() && checksums.ModTime.Equal(info.ModTime())
+	}
+
+	if known, ok := s.blobChecksums.Load(blobKey); ok && matches(known.(IsoChecksums)) {
+		return known.(IsoChecksums), nil
+	}
+
+	isos, err := store.ListIsos()
+	if err != nil {
+		return IsoChecksums{}, err
+	}
+
+	for _, iso := range isos {
+		if iso.BlobKey == blobKey && matches(iso.Checksums) {
+			s.blobChecksums.Store(blobKey, iso.Checksums)
+			return iso.Checksums, nil
+		}
+	}
+
+	checksums, err := fileChecksums(blobPath)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return IsoChecksums{}, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	s.blobChecksums.Store(blobKey, checksums)
+	return checksums, nil
+}
+
+// releaseBlob removes a reference to the blob and deletes the blob once nothing references it
+func (s *clientWithStorage) releaseBlob(store Store, blobKey string) error {
+	if blobKey == "" {
+		// ISOs stored before the blob cache own their file
+		return nil
+	}
+
+	defer s.lockIso(blobLockPrefix + blobKey)()
+
+	references, err := store.ReferenceBlob(blobKey, -1)
+	if err != nil || references > 0 {
+		return err
+	}
+
+	err = os.Remove(store.BlobPath(blobKey))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// tempFileSuffix marks files that are still written, they are renamed to their final name when complete
+const tempFileSuffix = ".tmp"
+
+// linkFile atomically replaces the file at linkPath with a hard link to target, or a symbolic link if hard links are
+// not supported. The link is created next to linkPath and renamed over it, so that linkPath is never missing.
+func linkFile(target, linkPath string) error {
+	tmpPath, err := tempFilePath(linkPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(target, tmpPath)
+	if err != nil {
+		err = os.Symlink(target, tmpPath)
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, linkPath)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+
+	return err
+}
+
+// tempFilePath reserves a unique name for a temporary file next to filePath. Renaming within a folder is atomic.
+func tempFilePath(filePath string) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*"+tempFileSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	_ = tmp.Close()
+	return tmp.Name(), os.Remove(tmp.Name())
+}
+
+// createIsoFileWithUii builds the ISO into a temporary file and renames it to filePath once it is complete. If the
+// build fails or the context is canceled, the partial file is removed and the previous file at filePath stays in
+// place. It returns the checksums of the new file.
+func (s *clientWithStorage) createIsoFileWithUii(ctx context.Context, iso Iso, filePath string) (IsoChecksums, error) {
+	args, opts := buildRequest(iso)
+
+	tmpPath, err := tempFilePath(filePath)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return IsoChecksums{}, fmt.Errorf("%w to %s: %s", ErrDownloadFailed, filePath, err.Error())
+	}
+	defer os.Remove(tmpPath)
+
+	release, err := s.requestLimiter().acquireBuild(ctx, "build "+iso.Name)
+	if err != nil {
+		return IsoChecksums{}, err
+	}
+	defer release()
+
+	err = s.VirtomizeClient.Build(ctx, tmpPath, args, opts)
+	if ctx.Err() != nil {
+		// canceled by the user or the timeout of the operation, the error of the client might only be a broken download
+		return IsoChecksums{}, fmt.Errorf("building %s: %w", filePath, ctx.Err())
+	}
+
+	if err != nil {
+		// the client only returns path errors when writing the downloaded file
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			//nolint: errorlint // can't have two errors
+			return IsoChecksums{}, fmt.Errorf("%w to %s: %s", ErrDownloadFailed, filePath, err.Error())
+		}
+
+		//nolint: errorlint // can't have two errors
+		return IsoChecksums{}, fmt.Errorf("%w: %s", ErrBuildFailed, err.Error())
+	}
+
+	// other clients might create the file readable by everyone, the ISO contains secrets of the host
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		//nolint: errorlint // can't have two errors
+		return IsoChecksums{}, fmt.Errorf("%w to %s: %s", ErrDownloadFailed, filePath, err.Error())
+	}
+
+	checksums, err := verifyIsoFile(tmpPath)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return IsoChecksums{}, fmt.Errorf("%w to %s: %s", ErrDownloadFailed, filePath, err.Error())
+	}
+
+	// replaces the directory entry only, hard links of other resources keep the previous file
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		//nolint: errorlint // can't have two errors
+		return IsoChecksums{}, fmt.Errorf("%w to %s: %s", ErrDownloadFailed, filePath, err.Error())
+	}
+
+	return checksums, nil
 }
 
-// refreshIso recreates an Iso by reading the data from the db and requesting a new iso file from UII
-func (s *clientWithStorage) refreshIso(isoID string, db *bolt.DB) error {
-	iso, err := readIso(db, isoID)
+// verifyIsoFile checks that the build produced a non-empty regular file and hashes it
+func verifyIsoFile(filePath string) (IsoChecksums, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return IsoChecksums{}, err
+	}
+
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return IsoChecksums{}, fmt.Errorf("%s is empty or not a regular file", filePath)
+	}
+
+	return fileChecksums(filePath)
+}
+
+// refreshIso recreates the ISO file for the build inputs of the record and writes the record once the file is in
+// place. The file is requested from UII as selected by build, or if no other ISO with the same build inputs holds it.
+func (s *clientWithStorage) refreshIso(ctx context.Context, store Store, iso StoredIso, build blobBuild) error {
+	blobKey := isoFingerprint(iso.Iso)
+	newBlob := blobKey != iso.BlobKey
+	localPath, checksums, err := s.acquireBlob(ctx, store, iso.Iso, blobKey, build, newBlob)
 	if err != nil {
 		return err
 	}
 
-	// remove old file and update to new local path - just in case the path changes
-	_ = os.Remove(iso.LocalPath)
-	localPath, err := s.createIsoFileWithUii(iso.Iso)
+	now := s.now()
+	refreshed, err := store.SaveIsoFile(ctx, StoredIso{
+		ID:           iso.ID,
+		Iso:          iso.Iso,
+		LocalPath:    localPath,
+		CreationTime: now,
+		Fingerprint:  blobKey,
+		BlobKey:      blobKey,
+		AccessTime:   now,
+		Checksums:    checksums,
+	})
+	if err == nil {
+		err = s.writeChecksumFile(refreshed)
+	}
+
+	if err == nil {
+		err = store.WriteIso(ctx, refreshed)
+	}
 
 	if err != nil {
-		log.Fatal(err)
+		if newBlob {
+			_ = s.releaseBlob(store, blobKey)
+		}
 		return err
 	}
 
-	return updateIso(db, isoID, StoredIso{isoID, iso.Iso, localPath, s.TimeProvider.Now()})
+	if !newBlob {
+		return nil
+	}
+
+	return s.releaseBlob(store, iso.BlobKey)
 }
 
-func (s *clientWithStorage) isExpired(iso StoredIso) bool {
-	return iso.CreationTime.Before(s.TimeProvider.Now().Add(-48 * time.Hour))
+// ExpiryTime returns the time after which the ISO will be rebuilt. It returns false if the ISO never expires.
+func (s *clientWithStorage) ExpiryTime(iso StoredIso) (time.Time, bool) {
+	if s.rebuildPolicy(iso) == rebuildNever {
+		return time.Time{}, false
+	}
+
+	return iso.CreationTime.Add(s.rebuildAfter(iso)), true
+}
+
+// IsExpired checks if the ISO is older than its configured lifetime
+func (s *clientWithStorage) IsExpired(iso StoredIso) bool {
+	expiry, expires := s.ExpiryTime(iso)
+	return expires && expiry.Before(s.now())
+}
+
+// rebuildAfter returns the lifetime of the ISO, falling back to the provider default
+func (s *clientWithStorage) rebuildAfter(iso StoredIso) time.Duration {
+	if iso.Rebuild.After != "" {
+		after, err := time.ParseDuration(iso.Rebuild.After)
+		if err == nil {
+			return after
+		}
+	}
+
+	if s.RebuildAfter > 0 {
+		return s.RebuildAfter
+	}
+
+	return defaultRebuildAfter
+}
+
+// rebuildPolicy returns the rebuild policy of the ISO, falling back to the provider default
+func (s *clientWithStorage) rebuildPolicy(iso StoredIso) string {
+	if iso.Rebuild.Policy != "" {
+		return iso.Rebuild.Policy
+	}
+
+	if s.RebuildPolicy != "" {
+		return s.RebuildPolicy
+	}
+
+	return defaultRebuildPolicy
 }
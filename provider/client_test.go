@@ -0,0 +1,736 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBuild records the arguments of a single Build call
+type fakeBuild struct {
+	FilePath string
+	Args     client.BuildArgs
+	Opts     client.BuildOpts
+}
+
+// fakeUiiClient is an IUiiClient that writes a small dummy ISO instead of calling UII
+type fakeUiiClient struct {
+	mu               sync.Mutex
+	builds           []fakeBuild
+	operatingSystems []client.OS
+	buildErr         error
+	// partialBuild makes failing builds write a part of the ISO first, like a download that broke off
+	partialBuild bool
+	// hangBuild makes builds write a part of the ISO and wait until the context is done, like a slow download
+	hangBuild bool
+	// fileMode is the mode the ISO is created with, 0600 if not set
+	fileMode os.FileMode
+}
+
+func (c *fakeUiiClient) Build(ctx context.Context, filePath string, args client.BuildArgs, opts client.BuildOpts) error {
+	c.mu.Lock()
+	c.builds = append(c.builds, fakeBuild{FilePath: filePath, Args: args, Opts: opts})
+	buildErr, partialBuild, hangBuild, fileMode := c.buildErr, c.partialBuild, c.hangBuild, c.fileMode
+	c.mu.Unlock()
+
+	if fileMode == 0 {
+		fileMode = 0600
+	}
+
+	if hangBuild {
+		_ = os.WriteFile(filePath, []byte("iso f"), 0600)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if buildErr != nil {
+		if partialBuild {
+			_ = os.WriteFile(filePath, []byte("iso f"), 0600)
+		}
+
+		return buildErr
+	}
+
+	return os.WriteFile(filePath, []byte("iso for "+args.Hostname), fileMode)
+}
+
+func (c *fakeUiiClient) OperatingSystems(_ context.Context) ([]client.OS, error) {
+	return c.operatingSystems, nil
+}
+
+func (c *fakeUiiClient) lastBuild() fakeBuild {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.builds[len(c.builds)-1]
+}
+
+// fixedTimeProvider is an ITimeProvider returning a settable time
+type fixedTimeProvider struct {
+	now time.Time
+}
+
+func (p *fixedTimeProvider) Now() time.Time {
+	return p.now
+}
+
+func newTestClient(t *testing.T) (*clientWithStorage, *fakeUiiClient) {
+	fake := &fakeUiiClient{}
+	c := &clientWithStorage{
+		VirtomizeClient: fake,
+		StorageFolder:   t.TempDir(),
+		TimeProvider:    &fixedTimeProvider{now: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	t.Cleanup(func() { assert.NoError(t, c.Close()) })
+
+	return c, fake
+}
+
+func testIso(name string) Iso {
+	return Iso{
+		Name:         name,
+		Distribution: "debian",
+		Version:      "11",
+		HostName:     "examplehost",
+		Networks:     []Network{{DHCP: true}},
+	}
+}
+
+func TestCreateIsoPassesSSHKeysAndPackages(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	iso := testIso("debian_iso")
+	iso.Optionals.SSHKeys = []string{"ssh-ed25519 AAAA first", "ssh-rsa AAAA second"}
+	iso.Optionals.Packages = []string{"vim", "curl"}
+
+	stored, err := c.CreateIso(context.Background(), iso)
+	assert.NoError(t, err)
+
+	build := fake.lastBuild()
+	assert.True(t, strings.HasPrefix(build.FilePath, path.Join(c.StorageFolder, blobsFolderName, stored.BlobKey)+"."))
+	assert.Equal(t, client.BuildArgs{
+		Distribution: "debian",
+		Version:      "11",
+		Hostname:     "examplehost",
+		Networks:     []client.NetworkArgs{{DHCP: true}},
+	}, build.Args)
+	assert.Equal(t, []string{"ssh-ed25519 AAAA first", "ssh-rsa AAAA second"}, build.Opts.SSHKeys)
+	assert.Equal(t, []string{"vim", "curl"}, build.Opts.Packages)
+
+	assert.Equal(t, iso.Optionals.SSHKeys, stored.Optionals.SSHKeys)
+	assert.Equal(t, iso.Optionals.Packages, stored.Optionals.Packages)
+
+	read, err := c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, iso.Optionals.SSHKeys, read.Optionals.SSHKeys)
+	assert.Equal(t, iso.Optionals.Packages, read.Optionals.Packages)
+}
+
+func TestSSHKeysAndPackagesRoundTripThroughModel(t *testing.T) {
+	model := isoResourceModel{
+		Name:         types.StringValue("debian_iso"),
+		Distribution: types.StringValue("debian"),
+		Version:      types.StringValue("11"),
+		Hostname:     types.StringValue("examplehost"),
+		SSHKeys:      []types.String{types.StringValue("ssh-ed25519 AAAA first")},
+		Packages:     []types.String{types.StringValue("vim"), types.StringValue("curl")},
+		Networks:     []networksModel{{Dhcp: types.BoolValue(true), NoInternet: types.BoolValue(false)}},
+	}
+
+	iso := parseIsoFromResourceModel(model)
+	assert.Equal(t, []string{"ssh-ed25519 AAAA first"}, iso.Optionals.SSHKeys)
+	assert.Equal(t, []string{"vim", "curl"}, iso.Optionals.Packages)
+
+	var state isoResourceModel
+	setIsoToModel(StoredIso{ID: "debian_iso", Iso: iso}, &state)
+	assert.Equal(t, model.SSHKeys, state.SSHKeys)
+	assert.Equal(t, model.Packages, state.Packages)
+
+	// unset lists stay unset
+	model.SSHKeys = nil
+	model.Packages = nil
+	iso = parseIsoFromResourceModel(model)
+	assert.Nil(t, iso.Optionals.SSHKeys)
+	assert.Nil(t, iso.Optionals.Packages)
+
+	setIsoToModel(StoredIso{ID: "debian_iso", Iso: iso}, &state)
+	assert.Nil(t, state.SSHKeys)
+	assert.Nil(t, state.Packages)
+}
+
+func TestRebuildMissingIsoFile(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	exists, err := c.IsoFileExists(context.Background(), stored)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, os.Remove(stored.LocalPath))
+	exists, err = c.IsoFileExists(context.Background(), stored)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// the cached blob is linked again
+	rebuilt, err := c.RebuildIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Len(t, fake.builds, 1)
+	assert.Equal(t, stored.LocalPath, rebuilt.LocalPath)
+
+	exists, err = c.IsoFileExists(context.Background(), rebuilt)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// without the blob UII builds the ISO again
+	assert.NoError(t, os.Remove(stored.LocalPath))
+	assert.NoError(t, os.Remove(path.Join(c.StorageFolder, blobsFolderName, stored.BlobKey)))
+
+	rebuilt, err = c.RebuildIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Len(t, fake.builds, 2)
+
+	exists, err = c.IsoFileExists(context.Background(), rebuilt)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestIsoExpiryAndRebuildPolicy(t *testing.T) {
+	c, fake := newTestClient(t)
+	clock := c.TimeProvider.(*fixedTimeProvider)
+	created := clock.now
+
+	// different hosts, so that every ISO has its own file
+	onRead := testIso("on_read")
+	onRead.HostName = "onread"
+	onRead.Rebuild = RebuildOpts{After: "1h", Policy: rebuildOnRead}
+	onApply := testIso("on_apply")
+	onApply.HostName = "onapply"
+	never := testIso("never")
+	never.HostName = "never"
+	never.Rebuild = RebuildOpts{Policy: rebuildNever}
+
+	for _, iso := range []Iso{onRead, onApply, never} {
+		_, err := c.CreateIso(context.Background(), iso)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, fake.builds, 3)
+
+	stored, err := c.ReadIso(context.Background(), "on_read")
+	assert.NoError(t, err)
+	expiry, expires := c.ExpiryTime(stored)
+	assert.True(t, expires)
+	assert.Equal(t, created.Add(time.Hour), expiry)
+
+	stored, err = c.ReadIso(context.Background(), "on_apply")
+	assert.NoError(t, err)
+	expiry, expires = c.ExpiryTime(stored)
+	assert.True(t, expires)
+	assert.Equal(t, created.Add(defaultRebuildAfter), expiry)
+
+	stored, err = c.ReadIso(context.Background(), "never")
+	assert.NoError(t, err)
+	_, expires = c.ExpiryTime(stored)
+	assert.False(t, expires)
+
+	// expire everything but "never"
+	clock.now = created.Add(100 * time.Hour)
+
+	stored, err = c.ReadIso(context.Background(), "on_read")
+	assert.NoError(t, err)
+	assert.Len(t, fake.builds, 4)
+	assert.Equal(t, clock.now, stored.CreationTime)
+	assert.False(t, c.IsExpired(stored))
+
+	stored, err = c.ReadIso(context.Background(), "on_apply")
+	assert.NoError(t, err)
+	assert.Len(t, fake.builds, 4)
+	assert.True(t, c.IsExpired(stored))
+
+	stored, err = c.ReadIso(context.Background(), "never")
+	assert.NoError(t, err)
+	assert.Len(t, fake.builds, 4)
+	assert.False(t, c.IsExpired(stored))
+
+	// provider defaults apply if the resource does not set anything
+	c.RebuildPolicy = rebuildNever
+	stored, err = c.ReadIso(context.Background(), "on_apply")
+	assert.NoError(t, err)
+	assert.False(t, c.IsExpired(stored))
+}
+
+func TestUpdateIsoOnlyRebuildsOnImageChanges(t *testing.T) {
+	c, fake := newTestClient(t)
+	clock := c.TimeProvider.(*fixedTimeProvider)
+	created := clock.now
+
+	iso := testIso("debian_iso")
+	stored, err := c.CreateIso(context.Background(), iso)
+	assert.NoError(t, err)
+	assert.Len(t, fake.builds, 1)
+	assert.Equal(t, isoFingerprint(iso), stored.Fingerprint)
+
+	// settings that don't affect the image
+	clock.now = created.Add(time.Hour)
+	iso.Rebuild = RebuildOpts{After: "24h", Policy: rebuildNever}
+	assert.NoError(t, c.UpdateIso(context.Background(), stored.ID, iso, false))
+	assert.Len(t, fake.builds, 1)
+
+	updated, err := c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.Fingerprint, updated.Fingerprint)
+	assert.Equal(t, created, updated.CreationTime)
+	assert.Equal(t, iso.Rebuild, updated.Rebuild)
+
+	// unset and empty lists result in the same image
+	iso.Optionals.Packages = []string{}
+	assert.NoError(t, c.UpdateIso(context.Background(), stored.ID, iso, false))
+	assert.Len(t, fake.builds, 1)
+
+	// changes of the image are built with the new inputs
+	iso.Optionals.Packages = []string{"vim"}
+	assert.NoError(t, c.UpdateIso(context.Background(), stored.ID, iso, false))
+	assert.Len(t, fake.builds, 2)
+	assert.Equal(t, []string{"vim"}, fake.lastBuild().Opts.Packages)
+
+	updated, err = c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, isoFingerprint(iso), updated.Fingerprint)
+	assert.NotEqual(t, stored.Fingerprint, updated.Fingerprint)
+	assert.Equal(t, clock.now, updated.CreationTime)
+
+	// expired ISOs are rebuilt when forced
+	assert.NoError(t, c.UpdateIso(context.Background(), stored.ID, iso, true))
+	assert.Len(t, fake.builds, 3)
+}
+
+func TestIsoFingerprint(t *testing.T) {
+	base := testIso("debian_iso")
+	fingerprint := isoFingerprint(base)
+	assert.Len(t, fingerprint, 64)
+
+	renamed := base
+	renamed.Name = "other_name"
+	renamed.Rebuild.Policy = rebuildNever
+	assert.Equal(t, fingerprint, isoFingerprint(renamed))
+
+	for _, modify := range []func(iso *Iso){
+		func(iso *Iso) { iso.Distribution = "ubuntu" },
+		func(iso *Iso) { iso.Version = "12" },
+		func(iso *Iso) { iso.HostName = "otherhost" },
+		func(iso *Iso) { iso.Optionals.Arch = "32" },
+		func(iso *Iso) { iso.Optionals.Locale = "de-DE" },
+		func(iso *Iso) { iso.Optionals.SSHKeys = []string{"ssh-ed25519 AAAA"} },
+		func(iso *Iso) { iso.Networks = []Network{{DHCP: true, NoInternet: true}} },
+	} {
+		changed := testIso("debian_iso")
+		modify(&changed)
+		assert.NotEqual(t, fingerprint, isoFingerprint(changed))
+	}
+}
+
+func TestClientErrorsDoNotPanic(t *testing.T) {
+	// no storage folder
+	c, _ := newTestClient(t)
+	c.StorageFolder = ""
+	assert.NotPanics(t, func() {
+		_, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+		assert.ErrorIs(t, err, ErrStoragePathNotSet)
+		_, err = c.ReadIso(context.Background(), "debian_iso")
+		assert.ErrorIs(t, err, ErrStoragePathNotSet)
+		_, err = c.RebuildIso(context.Background(), "debian_iso")
+		assert.ErrorIs(t, err, ErrStoragePathNotSet)
+		assert.ErrorIs(t, c.UpdateIso(context.Background(), "debian_iso", testIso("debian_iso"), false), ErrStoragePathNotSet)
+		assert.ErrorIs(t, c.DeleteIso(context.Background(), "debian_iso"), ErrStoragePathNotSet)
+	})
+
+	// the storage folder is not a directory
+	c, _ = newTestClient(t)
+	c.StorageFolder = path.Join(c.StorageFolder, "file")
+	assert.NoError(t, os.WriteFile(c.StorageFolder, []byte{}, 0600))
+	assert.NotPanics(t, func() {
+		_, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+		assert.ErrorIs(t, err, ErrStorage)
+		_, err = c.ReadIso(context.Background(), "debian_iso")
+		assert.ErrorIs(t, err, ErrStorage)
+		assert.ErrorIs(t, c.UpdateIso(context.Background(), "debian_iso", testIso("debian_iso"), false), ErrStorage)
+		assert.ErrorIs(t, c.DeleteIso(context.Background(), "debian_iso"), ErrStorage)
+	})
+
+	// unknown ISOs
+	c, fake := newTestClient(t)
+	assert.NotPanics(t, func() {
+		_, err := c.ReadIso(context.Background(), "unknown_iso")
+		assert.ErrorIs(t, err, ErrIsoNotFound)
+		_, err = c.RebuildIso(context.Background(), "unknown_iso")
+		assert.ErrorIs(t, err, ErrIsoNotFound)
+		assert.NoError(t, c.DeleteIso(context.Background(), "unknown_iso"))
+	})
+
+	// failing builds
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	other := testIso("other_iso")
+	other.HostName = "otherhost"
+
+	// without a cached file the ISO has to be built again
+	assert.NoError(t, os.Remove(stored.LocalPath))
+	assert.NoError(t, os.Remove(path.Join(c.StorageFolder, blobsFolderName, stored.BlobKey)))
+
+	fake.buildErr = errors.New("internal server error")
+	assert.NotPanics(t, func() {
+		_, err := c.CreateIso(context.Background(), other)
+		assert.ErrorIs(t, err, ErrBuildFailed)
+		_, err = c.RebuildIso(context.Background(), stored.ID)
+		assert.ErrorIs(t, err, ErrBuildFailed)
+		assert.ErrorIs(t, c.UpdateIso(context.Background(), stored.ID, testIso("debian_iso"), true), ErrBuildFailed)
+	})
+
+	// failing downloads
+	fake.buildErr = &os.PathError{Op: "open", Path: stored.LocalPath, Err: os.ErrPermission}
+	assert.NotPanics(t, func() {
+		_, err := c.RebuildIso(context.Background(), stored.ID)
+		assert.ErrorIs(t, err, ErrDownloadFailed)
+	})
+}
+
+func TestFailedRebuildKeepsPreviousIso(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			c, fake := newTestClient(t)
+			c.StorageBackend = backend
+			clock := c.TimeProvider.(*fixedTimeProvider)
+
+			iso := testIso("debian_iso")
+			iso.Rebuild = RebuildOpts{After: "1h", Policy: rebuildOnRead}
+			stored, err := c.CreateIso(context.Background(), iso)
+			assert.NoError(t, err)
+			blobPath := path.Join(c.StorageFolder, blobsFolderName, stored.BlobKey)
+
+			// the download breaks off after a part of the new ISO was written
+			fake.buildErr = &os.PathError{Op: "write", Path: stored.LocalPath, Err: errors.New("connection reset")}
+			fake.partialBuild = true
+
+			assert.ErrorIs(t, c.UpdateIso(context.Background(), stored.ID, iso, true), ErrDownloadFailed)
+
+			// the inputs of a failed update are not stored next to the previous file
+			changed := iso
+			changed.HostName = "otherhost"
+			assert.ErrorIs(t, c.UpdateIso(context.Background(), stored.ID, changed, false), ErrDownloadFailed)
+
+			clock.now = clock.now.Add(2 * time.Hour)
+			_, err = c.ReadIso(context.Background(), stored.ID)
+			assert.ErrorIs(t, err, ErrDownloadFailed)
+			assert.Len(t, fake.builds, 4)
+
+			// the previous ISO and its record are untouched, no partial files are left behind
+			assert.Equal(t, []byte("iso for examplehost"), readFile(t, stored.LocalPath))
+			assert.Equal(t, []byte("iso for examplehost"), readFile(t, blobPath))
+
+			store, err := c.openStore()
+			assert.NoError(t, err)
+			record, err := store.ReadIso(context.Background(), stored.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, stored.CreationTime, record.CreationTime)
+			assert.Equal(t, stored.BlobKey, record.BlobKey)
+			assert.Equal(t, iso, record.Iso)
+
+			blobs, err := os.ReadDir(path.Join(c.StorageFolder, blobsFolderName))
+			assert.NoError(t, err)
+			assert.Len(t, blobs, 1)
+
+			// the next successful build replaces the file
+			fake.buildErr = nil
+			stored, err = c.ReadIso(context.Background(), stored.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, clock.now, stored.CreationTime)
+			assert.Equal(t, []byte("iso for examplehost"), readFile(t, stored.LocalPath))
+		})
+	}
+}
+
+func TestCanceledBuildRemovesPartialFiles(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			c, fake := newTestClient(t)
+			c.StorageBackend = backend
+			fake.hangBuild = true
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := c.CreateIso(ctx, testIso("debian_iso"))
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+			// neither the partial download nor a record are left behind
+			_, err = c.ReadIso(context.Background(), "debian_iso")
+			assert.ErrorIs(t, err, ErrIsoNotFound)
+			assert.NoFileExists(t, path.Join(c.StorageFolder, "debian_iso.iso"))
+
+			blobs, err := os.ReadDir(path.Join(c.StorageFolder, blobsFolderName))
+			assert.NoError(t, err)
+			assert.Empty(t, blobs)
+
+			// the ISO is built once the build is not interrupted
+			fake.hangBuild = false
+			stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("iso for examplehost"), readFile(t, stored.LocalPath))
+		})
+	}
+}
+
+func TestWaitingForLockedIsoIsCanceled(t *testing.T) {
+	c, _ := newTestClient(t)
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	// another operation on the same ISO holds the lock
+	unlock := c.lockIso(stored.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.ReadIso(ctx, stored.ID)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, c.DeleteIso(ctx, stored.ID), context.DeadlineExceeded)
+
+	unlock()
+	_, err = c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+}
+
+func TestCreateIsosConcurrently(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			iso := testIso(fmt.Sprintf("iso_%d", i))
+			iso.HostName = fmt.Sprintf("host%d", i)
+
+			stored, err := c.CreateIso(context.Background(), iso)
+			assert.NoError(t, err)
+			assert.NoError(t, c.UpdateIso(context.Background(), stored.ID, iso, true))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, fake.builds, 40)
+	for i := 0; i < 20; i++ {
+		stored, err := c.ReadIso(context.Background(), fmt.Sprintf("iso_%d", i))
+		assert.NoError(t, err)
+		assert.FileExists(t, stored.LocalPath)
+	}
+}
+
+func TestStorageLockedByAnotherProcess(t *testing.T) {
+	c, _ := newTestClient(t)
+	_, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	other := &clientWithStorage{
+		VirtomizeClient: &fakeUiiClient{},
+		StorageFolder:   c.StorageFolder,
+		TimeProvider:    c.TimeProvider,
+		LockTimeout:     50 * time.Millisecond,
+	}
+	_, err = other.ReadIso(context.Background(), "debian_iso")
+	assert.ErrorIs(t, err, ErrStorageLocked)
+
+	// the lock is released on close
+	assert.NoError(t, c.Close())
+	_, err = other.ReadIso(context.Background(), "debian_iso")
+	assert.NoError(t, err)
+	assert.NoError(t, other.Close())
+}
+
+func TestIdenticalIsosShareOneFile(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			c, fake := newTestClient(t)
+			c.StorageBackend = backend
+
+			var stored []StoredIso
+			for _, name := range []string{"first", "second", "third"} {
+				iso, err := c.CreateIso(context.Background(), testIso(name))
+				assert.NoError(t, err)
+				stored = append(stored, iso)
+			}
+
+			// one build for all three, every resource has its own path to the same file
+			assert.Len(t, fake.builds, 1)
+			blobPath := path.Join(c.StorageFolder, blobsFolderName, stored[0].BlobKey)
+			// the build downloads next to the blob and is renamed when complete
+			assert.True(t, strings.HasPrefix(fake.lastBuild().FilePath, blobPath+"."))
+			assert.True(t, strings.HasSuffix(fake.lastBuild().FilePath, tempFileSuffix))
+
+			blob, err := os.Stat(blobPath)
+			assert.NoError(t, err)
+			for _, iso := range stored {
+				assert.Equal(t, stored[0].BlobKey, iso.BlobKey)
+				assert.Equal(t, path.Join(c.StorageFolder, iso.Name+".iso"), iso.LocalPath)
+
+				file, err := os.Stat(iso.LocalPath)
+				assert.NoError(t, err)
+				assert.True(t, os.SameFile(blob, file))
+			}
+
+			store, err := c.openStore()
+			assert.NoError(t, err)
+			references, err := store.ReferenceBlob(stored[0].BlobKey, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, references)
+
+			// the blob is only removed with the last reference
+			assert.NoError(t, c.DeleteIso(context.Background(), "first"))
+			assert.NoError(t, c.DeleteIso(context.Background(), "second"))
+			assert.NoFileExists(t, stored[0].LocalPath)
+			assert.FileExists(t, blobPath)
+			assert.FileExists(t, stored[2].LocalPath)
+
+			assert.NoError(t, c.DeleteIso(context.Background(), "third"))
+			assert.NoFileExists(t, blobPath)
+		})
+	}
+}
+
+func TestChangedIsoMovesToNewBlob(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	first, err := c.CreateIso(context.Background(), testIso("first"))
+	assert.NoError(t, err)
+	_, err = c.CreateIso(context.Background(), testIso("second"))
+	assert.NoError(t, err)
+	assert.Len(t, fake.builds, 1)
+
+	changed := testIso("second")
+	changed.Optionals.Packages = []string{"vim"}
+	assert.NoError(t, c.UpdateIso(context.Background(), "second", changed, false))
+	assert.Len(t, fake.builds, 2)
+
+	second, err := c.ReadIso(context.Background(), "second")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.BlobKey, second.BlobKey)
+	assert.Equal(t, []byte("iso for examplehost"), readFile(t, second.LocalPath))
+
+	store, err := c.openStore()
+	assert.NoError(t, err)
+	for blobKey, expected := range map[string]int{first.BlobKey: 1, second.BlobKey: 1} {
+		references, err := store.ReferenceBlob(blobKey, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, references)
+	}
+
+	// rebuilding an expired ISO does not change the files of other resources
+	_, err = c.CreateIso(context.Background(), testIso("third"))
+	assert.NoError(t, err)
+	assert.NoError(t, c.UpdateIso(context.Background(), "third", testIso("third"), true))
+	assert.Len(t, fake.builds, 3)
+	assert.FileExists(t, first.LocalPath)
+}
+
+func TestSharedBlobIsNotHashedAgain(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			c, _ := newTestClient(t)
+			c.StorageBackend = backend
+			first, err := c.CreateIso(context.Background(), testIso("first"))
+			assert.NoError(t, err)
+
+			// content changed behind the back of the provider with the same size and modification time stays
+			// unnoticed, which shows that the blob is not read again
+			blobPath := path.Join(c.StorageFolder, blobsFolderName, first.BlobKey)
+			info, err := os.Stat(blobPath)
+			assert.NoError(t, err)
+			assert.NoError(t, os.WriteFile(blobPath, []byte("iso for otherhost!!"), 0600))
+			assert.NoError(t, os.Chtimes(blobPath, info.ModTime(), info.ModTime()))
+
+			second, err := c.CreateIso(context.Background(), testIso("second"))
+			assert.NoError(t, err)
+			assert.Equal(t, first.Checksums, second.Checksums)
+
+			// the next run takes the checksums from the records
+			assert.NoError(t, c.Close())
+			next, fake := newTestClient(t)
+			next.StorageBackend = backend
+			next.StorageFolder = c.StorageFolder
+			third, err := next.CreateIso(context.Background(), testIso("third"))
+			assert.NoError(t, err)
+			assert.Empty(t, fake.builds)
+			assert.Equal(t, first.Checksums, third.Checksums)
+
+			// a blob modified later is hashed
+			assert.NoError(t, os.Chtimes(blobPath, info.ModTime().Add(time.Hour), info.ModTime().Add(time.Hour)))
+			fourth, err := next.CreateIso(context.Background(), testIso("fourth"))
+			assert.NoError(t, err)
+			assert.NotEqual(t, first.Checksums.SHA256, fourth.Checksums.SHA256)
+		})
+	}
+}
+
+func TestCorruptSharedBlobIsRepairedOnce(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			c, fake := newTestClient(t)
+			c.StorageBackend = backend
+			first, err := c.CreateIso(context.Background(), testIso("first"))
+			assert.NoError(t, err)
+			second, err := c.CreateIso(context.Background(), testIso("second"))
+			assert.NoError(t, err)
+
+			// both ISOs link to the corrupted file
+			blobPath := path.Join(c.StorageFolder, blobsFolderName, first.BlobKey)
+			assert.NoError(t, os.WriteFile(blobPath, []byte("corrupt"), 0600))
+			assert.ErrorIs(t, c.VerifyIsoFile(first), ErrIsoFileCorrupt)
+			assert.ErrorIs(t, c.VerifyIsoFile(second), ErrIsoFileCorrupt)
+
+			repaired, err := c.RepairIso(context.Background(), first.ID)
+			assert.NoError(t, err)
+			assert.Len(t, fake.builds, 2)
+			assert.NoError(t, c.VerifyIsoFile(repaired))
+
+			// the other ISO is linked to the repaired blob
+			relinked, err := c.RepairIso(context.Background(), second.ID)
+			assert.NoError(t, err)
+			assert.Len(t, fake.builds, 2)
+			assert.NoError(t, c.VerifyIsoFile(relinked))
+			assert.Equal(t, repaired.Checksums, relinked.Checksums)
+			assert.Equal(t, []byte("iso for examplehost"), readFile(t, relinked.LocalPath))
+		})
+	}
+}
+
+func TestConcurrentIdenticalIsosAreBuiltOnce(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := c.CreateIso(context.Background(), testIso(fmt.Sprintf("iso_%d", i)))
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, fake.builds, 1)
+}
+
+func readFile(t *testing.T, filePath string) []byte {
+	data, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	return data
+}
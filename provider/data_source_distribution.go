@@ -0,0 +1,237 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure the implementation satisfies the expected interfaces.
+var (
+	_ datasource.DataSource              = &DistributionDataSource{}
+	_ datasource.DataSourceWithConfigure = &DistributionDataSource{}
+)
+
+var (
+	ErrInvalidVersionConstraint = errors.New("invalid version constraint")
+	ErrNoMatchingDistribution   = errors.New("no supported operating system matches")
+)
+
+const versionConstraintKey = "version_constraint"
+
+// NewDistributionDataSource is a helper function to simplify the provider implementation.
+func NewDistributionDataSource() datasource.DataSource {
+	return &DistributionDataSource{}
+}
+
+// DistributionDataSource resolves the newest supported version of a distribution.
+type DistributionDataSource struct {
+	client *clientWithStorage
+}
+
+// distributionDataSourceModel maps the data source schema data.
+type distributionDataSourceModel struct {
+	ID                types.String `tfsdk:"id"`
+	Distribution      types.String `tfsdk:"distribution"`
+	VersionConstraint types.String `tfsdk:"version_constraint"`
+	Architecture      types.String `tfsdk:"architecture"`
+	Version           types.String `tfsdk:"version"`
+	DisplayName       types.String `tfsdk:"display_name"`
+}
+
+// Metadata returns the data source type name.
+func (d *DistributionDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_distribution"
+}
+
+// Schema defines the schema for the data source.
+func (d *DistributionDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Resolves the newest version of a distribution supported by Virtomize UII.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed: true,
+			},
+			distributionKey: schema.StringAttribute{
+				Required:            true,
+				Description:         "The distribution, for example \"debian\"",
+				MarkdownDescription: "The distribution, for example `debian`",
+			},
+			versionConstraintKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "A version constraint the resolved version has to satisfy, for example \">= 11, < 13\". Defaults to the newest version.",
+				MarkdownDescription: "A version constraint the resolved version has to satisfy, for example `>= 11, < 13`. Defaults to the newest version.",
+			},
+			architectureKey: schema.StringAttribute{
+				Optional:            true,
+				Computed:            true,
+				Description:         "The architecture variant of the OS, for example \"64\". If not set, any architecture is accepted, x86_64 and 64 are preferred if several architectures share the selected version.",
+				MarkdownDescription: "The architecture variant of the OS, for example `64`. If not set, any architecture is accepted, `x86_64` and `64` are preferred if several architectures share the selected version.",
+			},
+			versionKey: schema.StringAttribute{
+				Computed:    true,
+				Description: "The resolved version of the distribution as used by the virtomize_iso resource.",
+			},
+			displayNameKey: schema.StringAttribute{
+				Computed:    true,
+				Description: "A human readable name of the resolved operating system.",
+			},
+		},
+	}
+}
+
+func (d *DistributionDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+
+	c, ok := req.ProviderData.(*clientWithStorage)
+
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Data Source Configure Type",
+			fmt.Sprintf("Expected *clientWithStorage, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+
+		return
+	}
+
+	d.client = c
+}
+
+// Read refreshes the Terraform state with the latest data.
+func (d *DistributionDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var state distributionDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if d.client == nil {
+		resp.Diagnostics.AddError(errClientInit, errClientInitDesc)
+		return
+	}
+
+	constraints, err := parseVersionConstraint(state.VersionConstraint.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(versionConstraintKey), "Invalid version constraint", err.Error())
+		return
+	}
+
+	distributions, err := d.client.ReadDistributions(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error reading operating systems",
+			"Could not read the list of supported operating systems from UII: "+err.Error(),
+		)
+		return
+	}
+
+	selected, err := selectDistribution(distributions, state.Distribution.ValueString(), constraints, state.Architecture.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Error resolving distribution", err.Error())
+		return
+	}
+
+	state.ID = types.StringValue(selected.Distribution + "-" + selected.Version + "-" + selected.Architecture)
+	state.Architecture = types.StringValue(selected.Architecture)
+	state.Version = types.StringValue(selected.Version)
+	state.DisplayName = types.StringValue(selected.DisplayName)
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// parseVersionConstraint parses a version constraint, an empty constraint matches every version
+func parseVersionConstraint(constraint string) (version.Constraints, error) {
+	if constraint == "" {
+		return nil, nil
+	}
+
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return nil, fmt.Errorf("%w for %s, error: %s current value: %s",
+			ErrInvalidVersionConstraint,
+			versionConstraintKey,
+			err.Error(),
+			constraint)
+	}
+
+	return constraints, nil
+}
+
+// preferredArchitectures are selected first if several architectures share the newest version.
+var preferredArchitectures = []string{"x86_64", "64"}
+
+// preferArchitecture orders the architectures, the preferred ones first and the others lexically.
+func preferArchitecture(a, b string) bool {
+	rank := func(architecture string) int {
+		for i, preferred := range preferredArchitectures {
+			if architecture == preferred {
+				return i
+			}
+		}
+
+		return len(preferredArchitectures)
+	}
+
+	if rank(a) != rank(b) {
+		return rank(a) < rank(b)
+	}
+
+	return a < b
+}
+
+// selectDistribution returns the newest operating system of the distribution that satisfies the constraints.
+// Versions that are not numeric (e.g. "tumbleweed") are only used if no constraint is given and no numeric version exists.
+func selectDistribution(distributions []client.OS, distribution string, constraints version.Constraints, architecture string) (client.OS, error) {
+	var best client.OS
+	var bestVersion *version.Version
+	for _, item := range distributions {
+		if item.Distribution != distribution {
+			continue
+		}
+
+		if architecture != "" && item.Architecture != architecture {
+			continue
+		}
+
+		v, err := version.NewVersion(item.Version)
+		if err != nil {
+			if constraints == nil && bestVersion == nil && (best.Distribution == "" || item.Version > best.Version ||
+				item.Version == best.Version && preferArchitecture(item.Architecture, best.Architecture)) {
+				// keep non-numeric versions as a fallback for rolling releases
+				best = item
+			}
+			continue
+		}
+
+		if constraints != nil && !constraints.Check(v) {
+			continue
+		}
+
+		// the order of the catalog must not matter, otherwise the result could change between runs
+		if bestVersion == nil || v.GreaterThan(bestVersion) || v.Equal(bestVersion) && preferArchitecture(item.Architecture, best.Architecture) {
+			best = item
+			bestVersion = v
+		}
+	}
+
+	if best.Distribution == "" {
+		return client.OS{}, fmt.Errorf("%w %s=%q, %s=%q, %s=%q",
+			ErrNoMatchingDistribution,
+			distributionKey, distribution,
+			versionConstraintKey, constraints.String(),
+			architectureKey, architecture)
+	}
+
+	return best, nil
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"testing"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistributionSelection(t *testing.T) {
+	debian10 := client.OS{Architecture: "64", DisplayName: "Debian 10 x64", Distribution: "debian", Version: "10"}
+	debian11 := client.OS{Architecture: "64", DisplayName: "Debian 11 x64", Distribution: "debian", Version: "11"}
+	debian12 := client.OS{Architecture: "64", DisplayName: "Debian 12 x64", Distribution: "debian", Version: "12"}
+	debian12x32 := client.OS{Architecture: "32", DisplayName: "Debian 12 x32", Distribution: "debian", Version: "12"}
+	ubuntu2004 := client.OS{Architecture: "64", DisplayName: "Ubuntu 20.04 x64", Distribution: "ubuntu", Version: "20.04"}
+	ubuntu2204 := client.OS{Architecture: "64", DisplayName: "Ubuntu 22.04 x64", Distribution: "ubuntu", Version: "22.04"}
+	tumbleweed := client.OS{Architecture: "64", DisplayName: "openSUSE Tumbleweed x64", Distribution: "opensuse", Version: "tumbleweed"}
+	all := []client.OS{debian10, debian12x32, debian12, debian11, ubuntu2204, ubuntu2004, tumbleweed}
+
+	resolve := func(distribution, constraint, architecture string) (client.OS, error) {
+		constraints, err := parseVersionConstraint(constraint)
+		assert.NoError(t, err)
+		return selectDistribution(all, distribution, constraints, architecture)
+	}
+
+	selected, err := resolve("debian", "", "64")
+	assert.NoError(t, err)
+	assert.Equal(t, debian12, selected)
+
+	selected, err = resolve("debian", ">= 10, < 12", "")
+	assert.NoError(t, err)
+	assert.Equal(t, debian11, selected)
+
+	selected, err = resolve("debian", "", "32")
+	assert.NoError(t, err)
+	assert.Equal(t, debian12x32, selected)
+
+	selected, err = resolve("ubuntu", ">= 20", "64")
+	assert.NoError(t, err)
+	assert.Equal(t, ubuntu2204, selected)
+
+	selected, err = resolve("ubuntu", "~> 20.0", "64")
+	assert.NoError(t, err)
+	assert.Equal(t, ubuntu2004, selected)
+
+	selected, err = resolve("opensuse", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, tumbleweed, selected)
+
+	_, err = resolve("debian", ">= 13", "")
+	assert.ErrorIs(t, err, ErrNoMatchingDistribution)
+
+	_, err = resolve("opensuse", ">= 15", "")
+	assert.ErrorIs(t, err, ErrNoMatchingDistribution)
+
+	_, err = resolve("centos", "", "")
+	assert.ErrorIs(t, err, ErrNoMatchingDistribution)
+
+	_, err = parseVersionConstraint("newest please")
+	assert.ErrorIs(t, err, ErrInvalidVersionConstraint)
+}
+
+func TestDistributionSelectionIgnoresCatalogOrder(t *testing.T) {
+	x64 := client.OS{Architecture: "x86_64", DisplayName: "Debian 12", Distribution: "debian", Version: "12"}
+	arm := client.OS{Architecture: "aarch64", DisplayName: "Debian 12 ARM", Distribution: "debian", Version: "12"}
+	i386 := client.OS{Architecture: "i386", DisplayName: "Debian 12 i386", Distribution: "debian", Version: "12"}
+	rollingArm := client.OS{Architecture: "aarch64", DisplayName: "Tumbleweed ARM", Distribution: "opensuse", Version: "tumbleweed"}
+	rollingX64 := client.OS{Architecture: "x86_64", DisplayName: "Tumbleweed", Distribution: "opensuse", Version: "tumbleweed"}
+
+	for _, catalog := range [][]client.OS{
+		{x64, arm, i386, rollingArm, rollingX64},
+		{i386, arm, x64, rollingX64, rollingArm},
+		{arm, i386, x64, rollingArm, rollingX64},
+	} {
+		selected, err := selectDistribution(catalog, "debian", nil, "")
+		assert.NoError(t, err)
+		assert.Equal(t, x64, selected)
+
+		selected, err = selectDistribution(catalog, "opensuse", nil, "")
+		assert.NoError(t, err)
+		assert.Equal(t, rollingX64, selected)
+	}
+
+	// without a preferred architecture the first one in lexical order is selected
+	for _, catalog := range [][]client.OS{{arm, i386}, {i386, arm}} {
+		selected, err := selectDistribution(catalog, "debian", nil, "")
+		assert.NoError(t, err)
+		assert.Equal(t, arm, selected)
+	}
+}
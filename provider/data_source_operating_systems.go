@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure the implementation satisfies the expected interfaces.
+var (
+	_ datasource.DataSource              = &OperatingSystemsDataSource{}
+	_ datasource.DataSourceWithConfigure = &OperatingSystemsDataSource{}
+)
+
+const operatingSystemsKey = "operating_systems"
+const versionPrefixKey = "version_prefix"
+const displayNameKey = "display_name"
+
+// NewOperatingSystemsDataSource is a helper function to simplify the provider implementation.
+func NewOperatingSystemsDataSource() datasource.DataSource {
+	return &OperatingSystemsDataSource{}
+}
+
+// OperatingSystemsDataSource lists the operating systems supported by UII.
+type OperatingSystemsDataSource struct {
+	client *clientWithStorage
+}
+
+// operatingSystemsDataSourceModel maps the data source schema data.
+type operatingSystemsDataSourceModel struct {
+	ID               types.String           `tfsdk:"id"`
+	Distribution     types.String           `tfsdk:"distribution"`
+	VersionPrefix    types.String           `tfsdk:"version_prefix"`
+	Architecture     types.String           `tfsdk:"architecture"`
+	OperatingSystems []operatingSystemModel `tfsdk:"operating_systems"`
+}
+
+// operatingSystemModel maps a single entry of the UII operating system catalog.
+type operatingSystemModel struct {
+	Distribution types.String `tfsdk:"distribution"`
+	Version      types.String `tfsdk:"version"`
+	Architecture types.String `tfsdk:"architecture"`
+	DisplayName  types.String `tfsdk:"display_name"`
+}
+
+// Metadata returns the data source type name.
+func (d *OperatingSystemsDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_operating_systems"
+}
+
+// Schema defines the schema for the data source.
+func (d *OperatingSystemsDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Lists the operating systems supported by Virtomize UII.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed: true,
+			},
+			distributionKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "Only list operating systems of this distribution, for example \"debian\".",
+				MarkdownDescription: "Only list operating systems of this distribution, for example `debian`.",
+			},
+			versionPrefixKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "Only list operating systems whose version starts with this prefix, for example \"22.\".",
+				MarkdownDescription: "Only list operating systems whose version starts with this prefix, for example `22.`.",
+			},
+			architectureKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "Only list operating systems of this architecture, for example \"64\".",
+				MarkdownDescription: "Only list operating systems of this architecture, for example `64`.",
+			},
+			operatingSystemsKey: schema.ListNestedAttribute{
+				Computed:    true,
+				Description: "The operating systems matching the filters.",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						distributionKey: schema.StringAttribute{
+							Computed:    true,
+							Description: "The distribution name as used by the virtomize_iso resource.",
+						},
+						versionKey: schema.StringAttribute{
+							Computed:    true,
+							Description: "The version of the distribution as used by the virtomize_iso resource.",
+						},
+						architectureKey: schema.StringAttribute{
+							Computed:    true,
+							Description: "The architecture variant of the operating system.",
+						},
+						displayNameKey: schema.StringAttribute{
+							Computed:    true,
+							Description: "A human readable name of the operating system.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *OperatingSystemsDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+
+	c, ok := req.ProviderData.(*clientWithStorage)
+
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Data Source Configure Type",
+			fmt.Sprintf("Expected *clientWithStorage, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+
+		return
+	}
+
+	d.client = c
+}
+
+// Read refreshes the Terraform state with the latest data.
+func (d *OperatingSystemsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var state operatingSystemsDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if d.client == nil {
+		resp.Diagnostics.AddError(errClientInit, errClientInitDesc)
+		return
+	}
+
+	distributions, err := d.client.ReadDistributions(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError(
+			"Error reading operating systems",
+			"Could not read the list of supported operating systems from UII: "+err.Error(),
+		)
+		return
+	}
+
+	filtered := filterOperatingSystems(
+		distributions,
+		state.Distribution.ValueString(),
+		state.VersionPrefix.ValueString(),
+		state.Architecture.ValueString(),
+	)
+
+	state.ID = types.StringValue(ProviderName + "_operating_systems")
+	state.OperatingSystems = transformOperatingSystemsToModel(filtered)
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// filterOperatingSystems returns the operating systems matching all non-empty filters
+func filterOperatingSystems(distributions []client.OS, distribution, versionPrefix, architecture string) []client.OS {
+	result := []client.OS{}
+	for _, item := range distributions {
+		if distribution != "" && item.Distribution != distribution {
+			continue
+		}
+
+		if versionPrefix != "" && !strings.HasPrefix(item.Version, versionPrefix) {
+			continue
+		}
+
+		if architecture != "" && item.Architecture != architecture {
+			continue
+		}
+
+		result = append(result, item)
+	}
+
+	return result
+}
+
+// transformOperatingSystemsToModel transforms the UII operating systems into the terraform data source model
+func transformOperatingSystemsToModel(distributions []client.OS) []operatingSystemModel {
+	result := []operatingSystemModel{}
+	for _, item := range distributions {
+		result = append(result, operatingSystemModel{
+			Distribution: types.StringValue(item.Distribution),
+			Version:      types.StringValue(item.Version),
+			Architecture: types.StringValue(item.Architecture),
+			DisplayName:  types.StringValue(item.DisplayName),
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,23 @@
+package provider
+
+import (
+	"testing"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperatingSystemsFilter(t *testing.T) {
+	debian11 := client.OS{Architecture: "64", DisplayName: "Debian 11 x64", Distribution: "debian", Version: "11"}
+	debian12 := client.OS{Architecture: "64", DisplayName: "Debian 12 x64", Distribution: "debian", Version: "12"}
+	ubuntu2204 := client.OS{Architecture: "64", DisplayName: "Ubuntu 22.04 x64", Distribution: "ubuntu", Version: "22.04"}
+	ubuntu2204x32 := client.OS{Architecture: "32", DisplayName: "Ubuntu 22.04 x32", Distribution: "ubuntu", Version: "22.04"}
+	all := []client.OS{debian11, debian12, ubuntu2204, ubuntu2204x32}
+
+	assert.Equal(t, all, filterOperatingSystems(all, "", "", ""))
+	assert.Equal(t, []client.OS{debian11, debian12}, filterOperatingSystems(all, "debian", "", ""))
+	assert.Equal(t, []client.OS{debian12}, filterOperatingSystems(all, "debian", "12", ""))
+	assert.Equal(t, []client.OS{ubuntu2204x32}, filterOperatingSystems(all, "ubuntu", "22.", "32"))
+	assert.Equal(t, []client.OS{}, filterOperatingSystems(all, "centos", "", ""))
+	assert.Equal(t, []client.OS{}, filterOperatingSystems(nil, "", "", ""))
+}
@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	encryptionKeyKey         = "encryption_key"
+	previousEncryptionKeyKey = "previous_encryption_keys"
+	// nolint: gosec // only the name
+	EncryptionKeyEnvName = "VIRTOMIZE_CACHE_ENCRYPTION_KEY"
+)
+
+var (
+	ErrInvalidEncryptionKey = errors.New("base64 encoded AES key of 16, 24 or 32 bytes required")
+	ErrEncryptionKeyMissing = errors.New("the iso storage is encrypted with a key that is not configured")
+)
+
+// encryptedRecord is the envelope of an encrypted record. Plain records never have the "encrypted" field.
+type encryptedRecord struct {
+	Encrypted *encryptedPayload `json:"encrypted,omitempty"`
+}
+
+type encryptedPayload struct {
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// recordCodec marshals the ISO records of the stores. If a key is configured the records are encrypted with
+// AES-GCM, the id of the ISO is authenticated with the record so that records can't be swapped. A nil codec writes
+// plain JSON.
+type recordCodec struct {
+	// currentKeyID identifies the key new records are encrypted with, it is empty if new records are not encrypted
+	currentKeyID string
+	// keys holds the current and the previous keys by their id, previous keys are only used for decrypting
+	keys map[string]cipher.AEAD
+}
+
+// newRecordCodec creates a codec encrypting with the current key and decrypting with all keys. The keys are base64
+// encoded. Without keys no codec is needed and nil is returned.
+func newRecordCodec(currentKey string, previousKeys []string) (*recordCodec, error) {
+	if currentKey == "" && len(previousKeys) == 0 {
+		return nil, nil
+	}
+
+	codec := &recordCodec{keys: map[string]cipher.AEAD{}}
+	for i, key := range append([]string{currentKey}, previousKeys...) {
+		if key == "" {
+			continue
+		}
+
+		keyID, aead, err := parseEncryptionKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			codec.currentKeyID = keyID
+		}
+
+		codec.keys[keyID] = aead
+	}
+
+	return codec, nil
+}
+
+// parseEncryptionKey decodes the base64 encoded key and returns its id and cipher
+func parseEncryptionKey(key string) (string, cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return "", nil, fmt.Errorf("%w: %s", ErrInvalidEncryptionKey, err.Error())
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return "", nil, fmt.Errorf("%w: %s", ErrInvalidEncryptionKey, err.Error())
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash := sha256.Sum256(raw)
+	return hex.EncodeToString(hash[:4]), aead, nil
+}
+
+// Marshal encodes the record, encrypted with the current key if there is one
+func (c *recordCodec) Marshal(iso StoredIso) ([]byte, error) {
+	data, err := json.Marshal(iso)
+	if err != nil {
+		return nil, fmt.Errorf("could marshal iso: %w", err)
+	}
+
+	if c == nil || c.currentKeyID == "" {
+		return data, nil
+	}
+
+	aead := c.keys[c.currentKeyID]
+	payload := &encryptedPayload{KeyID: c.currentKeyID, Nonce: make([]byte, aead.NonceSize())}
+	if _, err := io.ReadFull(rand.Reader, payload.Nonce); err != nil {
+		return nil, fmt.Errorf("could not create nonce: %w", err)
+	}
+
+	payload.Ciphertext = aead.Seal(nil, payload.Nonce, data, []byte(iso.ID))
+	return json.Marshal(encryptedRecord{Encrypted: payload})
+}
+
+// Unmarshal decodes the record of the ISO with the given id, decrypting it if it is encrypted
+func (c *recordCodec) Unmarshal(isoID string, data []byte) (StoredIso, error) {
+	var iso StoredIso
+
+	var record encryptedRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return iso, corruptRecordError(isoID, err)
+	}
+
+	if record.Encrypted != nil {
+		var aead cipher.AEAD
+		if c != nil {
+			aead = c.keys[record.Encrypted.KeyID]
+		}
+
+		if aead == nil {
+			return iso, fmt.Errorf("%w: record of iso %s uses key %s", ErrEncryptionKeyMissing, isoID, record.Encrypted.KeyID)
+		}
+
+		var err error
+		data, err = aead.Open(nil, record.Encrypted.Nonce, record.Encrypted.Ciphertext, []byte(isoID))
+		if err != nil {
+			return iso, corruptRecordError(isoID, err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &iso); err != nil {
+		return iso, corruptRecordError(isoID, err)
+	}
+
+	return iso, nil
+}
+
+// NeedsRewrite checks if the record is not stored the way the codec writes it, i.e. it is encrypted with a previous
+// key or encrypted although it should be plain, or the other way around
+func (c *recordCodec) NeedsRewrite(data []byte) bool {
+	var record encryptedRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		// corrupt records are reported on read
+		return false
+	}
+
+	if record.Encrypted == nil {
+		return c != nil && c.currentKeyID != ""
+	}
+
+	return c == nil || record.Encrypted.KeyID != c.currentKeyID
+}
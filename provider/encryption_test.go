@@ -0,0 +1,196 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testEncryptionKey      = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	testOtherEncryptionKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func TestRecordCodec(t *testing.T) {
+	codec, err := newRecordCodec(testEncryptionKey, nil)
+	assert.NoError(t, err)
+
+	iso := StoredIso{ID: "debian_iso", Iso: testIso("debian_iso")}
+	iso.Optionals.Password = "$6$secret"
+	data, err := codec.Marshal(iso)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "examplehost")
+	assert.False(t, codec.NeedsRewrite(data))
+
+	decoded, err := codec.Unmarshal("debian_iso", data)
+	assert.NoError(t, err)
+	assert.Equal(t, iso, decoded)
+
+	// the record is bound to its id
+	_, err = codec.Unmarshal("other_iso", data)
+	assert.ErrorIs(t, err, ErrStorageCorrupt)
+
+	// plain records are read, but written encrypted
+	var plain *recordCodec
+	plainData, err := plain.Marshal(iso)
+	assert.NoError(t, err)
+	assert.Contains(t, string(plainData), "examplehost")
+	assert.True(t, codec.NeedsRewrite(plainData))
+	assert.False(t, plain.NeedsRewrite(plainData))
+
+	decoded, err = codec.Unmarshal("debian_iso", plainData)
+	assert.NoError(t, err)
+	assert.Equal(t, iso, decoded)
+
+	_, err = plain.Unmarshal("debian_iso", data)
+	assert.ErrorIs(t, err, ErrEncryptionKeyMissing)
+	assert.True(t, plain.NeedsRewrite(data))
+
+	// rotated keys
+	rotated, err := newRecordCodec(testOtherEncryptionKey, []string{testEncryptionKey})
+	assert.NoError(t, err)
+	assert.True(t, rotated.NeedsRewrite(data))
+	decoded, err = rotated.Unmarshal("debian_iso", data)
+	assert.NoError(t, err)
+	assert.Equal(t, iso, decoded)
+
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		_, err = newRecordCodec(key, nil)
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+		_, err = newRecordCodec("", []string{key})
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+	}
+
+	codec, err = newRecordCodec("", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, codec)
+}
+
+// rawRecord returns the record of the ISO as it is stored on disk
+func rawRecord(t *testing.T, store Store, isoID string) []byte {
+	switch s := store.(type) {
+	case *boltStore:
+		var data []byte
+		assert.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+			data = append([]byte(nil), tx.Bucket([]byte("DB")).Bucket([]byte("ISOS")).Get([]byte(isoID))...)
+			return nil
+		}))
+		return data
+	case *directoryStore:
+		data, err := os.ReadFile(s.recordPath(isoID))
+		assert.NoError(t, err)
+		return data
+	}
+
+	t.Fatalf("unknown store %T", store)
+	return nil
+}
+
+func TestStoreEncryptionAndKeyRotation(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			folder := t.TempDir()
+			open := func(key string, previousKeys ...string) (Store, error) {
+				return newStore(StorageConfig{Backend: backend, Folder: folder, EncryptionKey: key, PreviousEncryptionKeys: previousKeys})
+			}
+
+			// plain records of a cache without encryption are encrypted on open
+			store, err := open("")
+			assert.NoError(t, err)
+			iso := StoredIso{ID: "debian_iso", Iso: testIso("debian_iso")}
+			assert.NoError(t, store.WriteIso(context.Background(), iso))
+			assert.Contains(t, string(rawRecord(t, store, "debian_iso")), "examplehost")
+			assert.NoError(t, store.Close())
+
+			store, err = open(testEncryptionKey)
+			assert.NoError(t, err)
+			encrypted := rawRecord(t, store, "debian_iso")
+			assert.NotContains(t, string(encrypted), "examplehost")
+
+			read, err := store.ReadIso(context.Background(), "debian_iso")
+			assert.NoError(t, err)
+			assert.Equal(t, iso, read)
+			assert.NoError(t, store.Close())
+
+			// the key is required
+			_, err = open("")
+			assert.ErrorIs(t, err, ErrEncryptionKeyMissing)
+			_, err = open(testOtherEncryptionKey)
+			assert.ErrorIs(t, err, ErrEncryptionKeyMissing)
+
+			// rotation
+			store, err = open(testOtherEncryptionKey, testEncryptionKey)
+			assert.NoError(t, err)
+			assert.NotEqual(t, encrypted, rawRecord(t, store, "debian_iso"))
+			assert.NoError(t, store.Close())
+
+			store, err = open(testOtherEncryptionKey)
+			assert.NoError(t, err)
+			read, err = store.ReadIso(context.Background(), "debian_iso")
+			assert.NoError(t, err)
+			assert.Equal(t, iso, read)
+			assert.NoError(t, store.Close())
+
+			// decryption
+			store, err = open("", testOtherEncryptionKey)
+			assert.NoError(t, err)
+			assert.Contains(t, string(rawRecord(t, store, "debian_iso")), "examplehost")
+			assert.NoError(t, store.Close())
+		})
+	}
+}
+
+func TestStorePermissions(t *testing.T) {
+	folder := t.TempDir()
+	dbPath := path.Join(folder, DataBaseName)
+	store, err := newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	// existing databases are restricted as well
+	assert.NoError(t, os.Chmod(dbPath, 0644))
+	store, err = newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+	assert.NoError(t, err)
+	defer store.Close()
+
+	info, err := os.Stat(dbPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// folders of older versions are restricted as well
+	assert.NoError(t, os.Chmod(folder, 0755))
+	assert.NoError(t, os.Chmod(path.Join(folder, blobsFolderName), 0755))
+	directory, err := newStore(StorageConfig{Backend: storageBackendDirectory, Folder: folder})
+	assert.NoError(t, err)
+	defer directory.Close()
+	assert.NoError(t, directory.WriteIso(context.Background(), StoredIso{ID: "debian_iso"}))
+
+	for filePath, mode := range map[string]os.FileMode{
+		folder:                                                  0700,
+		path.Join(folder, blobsFolderName):                      0700,
+		path.Join(folder, recordsFolderName):                    0700,
+		path.Join(folder, referencesFolderName):                 0700,
+		path.Join(folder, recordsFolderName, "debian_iso.json"): 0600,
+	} {
+		info, err := os.Stat(filePath)
+		assert.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), filePath)
+	}
+
+	// the ISO contains secrets of the host, even if the client creates it readable by everyone
+	c, builds := newTestClient(t)
+	builds.fileMode = 0644
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	for _, filePath := range []string{stored.LocalPath, path.Join(c.StorageFolder, blobsFolderName, stored.BlobKey)} {
+		info, err := os.Stat(filePath)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), filePath)
+	}
+}
@@ -0,0 +1,254 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	maxCacheSizeKey = "max_cache_size"
+	maxCacheAgeKey  = "max_cache_age"
+)
+
+// byteSizeUnits are the supported units of cache sizes
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+var byteSizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([KMGT]i?B|B)?$`)
+
+// parseByteSize parses sizes like "500MB" or "1.5GiB" into bytes
+func parseByteSize(size string) (int64, error) {
+	match := byteSizePattern.FindStringSubmatch(size)
+	if match == nil {
+		return 0, fmt.Errorf("unknown size %q", size)
+	}
+
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	bytes := value * float64(byteSizeUnits[match[2]])
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", size)
+	}
+
+	return int64(bytes), nil
+}
+
+// cacheEntry is a file in the ISO cache together with the ISOs linking to it
+type cacheEntry struct {
+	key        string
+	path       string
+	isBlob     bool
+	isoIDs     []string
+	size       int64
+	lastAccess time.Time
+}
+
+// cacheKey identifies the file of the ISO in the cache: its blob, or its own file if it was stored before the blob
+// cache existed
+func cacheKey(iso StoredIso) string {
+	if iso.BlobKey != "" {
+		return iso.BlobKey
+	}
+
+	return iso.LocalPath
+}
+
+// markLive protects the file of the ISO from being evicted, as it is used by the current Terraform run
+func (s *clientWithStorage) markLive(key string) {
+	s.liveFiles.Store(key, true)
+}
+
+func (s *clientWithStorage) isLive(key string) bool {
+	_, live := s.liveFiles.Load(key)
+	return live
+}
+
+// EvictIsoFiles removes the least recently used ISO files from the cache until it is smaller than MaxCacheSize, and
+// all ISO files that were not used for MaxCacheAge. Files of ISOs used by the current run are never evicted. The
+// provider doesn't know the state though, so files of resources that the run skips, for example with -target, are
+// evicted like the files of deleted workspaces. The records of evicted ISOs are kept, their files are rebuilt by the
+// next apply. It returns the ids of the evicted ISOs.
+func (s *clientWithStorage) EvictIsoFiles() ([]string, error) {
+	if s.MaxCacheSize <= 0 && s.MaxCacheAge <= 0 {
+		return nil, nil
+	}
+
+	store, err := s.openStore()
+	if err != nil {
+		return nil, err
+	}
+
+	s.evictionMutex.Lock()
+	defer s.evictionMutex.Unlock()
+
+	entries, err := s.cacheEntries(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+
+	now := s.now()
+	var evicted []string
+	for _, entry := range entries {
+		expired := s.MaxCacheAge > 0 && now.Sub(entry.lastAccess) > s.MaxCacheAge
+		tooLarge := s.MaxCacheSize > 0 && total > s.MaxCacheSize
+		if !expired && !tooLarge {
+			// all remaining entries were used more recently
+			break
+		}
+
+		if s.isLive(entry.key) {
+			continue
+		}
+
+		isoIDs, err := s.evictEntry(store, entry)
+		if err != nil {
+			return evicted, err
+		}
+
+		if len(isoIDs) > 0 {
+			evicted = append(evicted, isoIDs...)
+			total -= entry.size
+		}
+	}
+
+	return evicted, nil
+}
+
+// evictAfterUse runs the eviction after an operation added files to the cache. Errors are ignored, as the operation
+// itself succeeded.
+func (s *clientWithStorage) evictAfterUse() {
+	_, _ = s.EvictIsoFiles()
+}
+
+// cacheEntries returns the files in the cache, the least recently used first
+func (s *clientWithStorage) cacheEntries(store Store) ([]*cacheEntry, error) {
+	isos, err := store.ListIsos()
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := map[string]*cacheEntry{}
+	var entries []*cacheEntry
+	for _, iso := range isos {
+		if iso.Evicted {
+			continue
+		}
+
+		lastAccess := iso.AccessTime
+		if lastAccess.Before(iso.CreationTime) {
+			lastAccess = iso.CreationTime
+		}
+
+		key := cacheKey(iso)
+		entry, ok := byKey[key]
+		if !ok {
+			entry = &cacheEntry{key: key, path: iso.LocalPath}
+			if iso.BlobKey != "" {
+				entry.path = store.BlobPath(iso.BlobKey)
+				entry.isBlob = true
+			}
+
+			info, err := os.Stat(entry.path)
+			if err != nil {
+				// nothing to evict
+				continue
+			}
+
+			entry.size = info.Size()
+			byKey[key] = entry
+			entries = append(entries, entry)
+		}
+
+		entry.isoIDs = append(entry.isoIDs, iso.ID)
+		if lastAccess.After(entry.lastAccess) {
+			entry.lastAccess = lastAccess
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].lastAccess.Before(entries[j].lastAccess)
+	})
+
+	return entries, nil
+}
+
+// evictEntry removes the file of the entry and the links of all ISOs to it, and marks the ISOs as evicted
+func (s *clientWithStorage) evictEntry(store Store, entry *cacheEntry) ([]string, error) {
+	// lock in the same order as the other operations, first the ISOs and then the blob
+	sort.Strings(entry.isoIDs)
+	for _, isoID := range entry.isoIDs {
+		defer s.lockIso(isoID)()
+	}
+
+	if entry.isBlob {
+		defer s.lockIso(blobLockPrefix + entry.key)()
+	}
+
+	// the file might have been used since the entries were listed
+	if s.isLive(entry.key) {
+		return nil, nil
+	}
+
+	var evicted []string
+	for _, isoID := range entry.isoIDs {
+		iso, err := store.ReadIso(context.Background(), isoID)
+		if errors.Is(err, ErrIsoNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return evicted, err
+		}
+
+		if iso.Evicted || cacheKey(iso) != entry.key {
+			continue
+		}
+
+		err = os.Remove(iso.LocalPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			//nolint: errorlint // can't have two errors
+			return evicted, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+		}
+		removeChecksumFile(iso.LocalPath)
+
+		iso.Evicted = true
+		if err := store.WriteIso(context.Background(), iso); err != nil {
+			return evicted, err
+		}
+
+		evicted = append(evicted, isoID)
+	}
+
+	err := os.Remove(entry.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		//nolint: errorlint // can't have two errors
+		return evicted, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	return evicted, nil
+}
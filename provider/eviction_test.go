@@ -0,0 +1,186 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClientOnFolder creates a test client for a later Terraform run on the storage folder of another client
+func newTestClientOnFolder(t *testing.T, previous *clientWithStorage, now time.Time) (*clientWithStorage, *fakeUiiClient) {
+	assert.NoError(t, previous.Close())
+
+	c, fake := newTestClient(t)
+	c.StorageFolder = previous.StorageFolder
+	c.TimeProvider = &fixedTimeProvider{now: now}
+	return c, fake
+}
+
+func createTestIsos(t *testing.T, c *clientWithStorage, start time.Time, names ...string) {
+	timeProvider, _ := c.TimeProvider.(*fixedTimeProvider)
+	for i, name := range names {
+		timeProvider.now = start.Add(time.Duration(i) * time.Hour)
+		iso := testIso(name)
+		iso.HostName = fmt.Sprintf("host%d", i)
+		_, err := c.CreateIso(context.Background(), iso)
+		assert.NoError(t, err)
+	}
+}
+
+func TestEvictLeastRecentlyUsedIsoFiles(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+			first, _ := newTestClient(t)
+			first.StorageBackend = backend
+			createTestIsos(t, first, start, "a", "b", "c")
+
+			// every ISO file has the same size
+			isoSize := int64(len("iso for host0"))
+			c, fake := newTestClientOnFolder(t, first, start.Add(24*time.Hour))
+			c.StorageBackend = backend
+			c.MaxCacheSize = 2 * isoSize
+
+			// b is used by this run, the oldest unused files are evicted
+			b, err := c.ReadIso(context.Background(), "b")
+			assert.NoError(t, err)
+			assert.Equal(t, start.Add(24*time.Hour), b.AccessTime)
+
+			store, err := c.openStore()
+			assert.NoError(t, err)
+			a, err := store.ReadIso(context.Background(), "a")
+			assert.NoError(t, err)
+			assert.True(t, a.Evicted)
+			assert.NoFileExists(t, a.LocalPath)
+			assert.NoFileExists(t, store.BlobPath(a.BlobKey))
+
+			for _, isoID := range []string{"b", "c"} {
+				iso, err := store.ReadIso(context.Background(), isoID)
+				assert.NoError(t, err)
+				assert.False(t, iso.Evicted)
+				assert.FileExists(t, iso.LocalPath)
+			}
+
+			// creating another ISO evicts c, b is still in use
+			d := testIso("d")
+			d.HostName = "host3"
+			_, err = c.CreateIso(context.Background(), d)
+			assert.NoError(t, err)
+
+			c3, err := store.ReadIso(context.Background(), "c")
+			assert.NoError(t, err)
+			assert.True(t, c3.Evicted)
+			assert.NoFileExists(t, c3.LocalPath)
+			assert.FileExists(t, b.LocalPath)
+
+			// evicted ISOs are not rebuilt by a refresh, only by the next apply
+			read, err := c.ReadIso(context.Background(), "a")
+			assert.NoError(t, err)
+			assert.True(t, read.Evicted)
+			assert.Len(t, fake.builds, 1)
+
+			assert.NoError(t, c.UpdateIso(context.Background(), "a", read.Iso, false))
+			rebuilt, err := c.ReadIso(context.Background(), "a")
+			assert.NoError(t, err)
+			assert.False(t, rebuilt.Evicted)
+			assert.FileExists(t, rebuilt.LocalPath)
+			assert.Len(t, fake.builds, 2)
+		})
+	}
+}
+
+func TestEvictExpiredIsoFiles(t *testing.T) {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	first, _ := newTestClient(t)
+	first.MaxCacheAge = 24 * time.Hour
+
+	// both ISOs share one file
+	for _, name := range []string{"a", "b"} {
+		_, err := first.CreateIso(context.Background(), testIso(name))
+		assert.NoError(t, err)
+	}
+
+	evicted, err := first.EvictIsoFiles()
+	assert.NoError(t, err)
+	assert.Empty(t, evicted)
+
+	c, fake := newTestClientOnFolder(t, first, start.Add(48*time.Hour))
+	c.MaxCacheAge = 24 * time.Hour
+
+	evicted, err = c.EvictIsoFiles()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, evicted)
+
+	store, err := c.openStore()
+	assert.NoError(t, err)
+	a, err := store.ReadIso(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.NoFileExists(t, store.BlobPath(a.BlobKey))
+
+	// the blob keeps its references, so that the ISOs can share it again
+	references, err := store.ReferenceBlob(a.BlobKey, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, references)
+
+	// an apply rebuilds the evicted file, the other ISO links to it again
+	assert.NoError(t, c.UpdateIso(context.Background(), "a", testIso("a"), false))
+	assert.Len(t, fake.builds, 1)
+
+	assert.NoError(t, c.UpdateIso(context.Background(), "b", testIso("b"), false))
+	b, err := c.ReadIso(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.False(t, b.Evicted)
+	assert.FileExists(t, b.LocalPath)
+	assert.Len(t, fake.builds, 1)
+}
+
+func TestLiveIsoFilesAreNeverEvicted(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.MaxCacheSize = 1
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	evicted, err := c.EvictIsoFiles()
+	assert.NoError(t, err)
+	assert.Empty(t, evicted)
+	assert.FileExists(t, stored.LocalPath)
+
+	// without limits nothing is evicted
+	c, _ = newTestClientOnFolder(t, c, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	evicted, err = c.EvictIsoFiles()
+	assert.NoError(t, err)
+	assert.Empty(t, evicted)
+	assert.FileExists(t, stored.LocalPath)
+}
+
+func TestEvictionOfResourcesSkippedByTarget(t *testing.T) {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	first, _ := newTestClient(t)
+	createTestIsos(t, first, start, "targeted", "skipped")
+
+	// a run with -target only reads the targeted ISO, the file of the other resource is evicted although it is
+	// still in the state
+	c, fake := newTestClientOnFolder(t, first, start.Add(24*time.Hour))
+	c.MaxCacheSize = int64(len("iso for host0"))
+	_, err := c.ReadIso(context.Background(), "targeted")
+	assert.NoError(t, err)
+
+	store, err := c.openStore()
+	assert.NoError(t, err)
+	skipped, err := store.ReadIso(context.Background(), "skipped")
+	assert.NoError(t, err)
+	assert.True(t, skipped.Evicted)
+	assert.NoFileExists(t, skipped.LocalPath)
+
+	// its next apply rebuilds the file at the same path
+	assert.NoError(t, c.UpdateIso(context.Background(), "skipped", skipped.Iso, false))
+	rebuilt, err := c.ReadIso(context.Background(), "skipped")
+	assert.NoError(t, err)
+	assert.Equal(t, skipped.LocalPath, rebuilt.LocalPath)
+	assert.FileExists(t, rebuilt.LocalPath)
+	assert.Len(t, fake.builds, 1)
+}
@@ -0,0 +1,296 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const collectGarbageKey = "collect_garbage"
+
+// ErrBucketNotConfigured is returned if the garbage of uploaded ISOs is collected without access to their bucket
+var ErrBucketNotConfigured = errors.New("the ISOs were uploaded to a bucket, which is not configured")
+
+// orphanGracePeriod is the age after which files without a record are garbage. Younger files might belong to a build
+// of a concurrent run, which writes the record once the build finished.
+const orphanGracePeriod = defaultCreateTimeout
+
+// GarbageCollectionCommand is the subcommand of the provider binary that collects the garbage in a storage folder
+const GarbageCollectionCommand = "gc"
+
+// GarbageReport lists the orphaned data in a storage folder
+type GarbageReport struct {
+	StorageFolder string `json:"storage_folder"`
+	DryRun        bool   `json:"dry_run"`
+	// OrphanedFiles are ISO files and blobs that no record refers to
+	OrphanedFiles []string `json:"orphaned_files"`
+	// OrphanedRecords are the ids of the ISOs whose file is gone
+	OrphanedRecords []string `json:"orphaned_records"`
+	// Bytes is the size of the orphaned files
+	Bytes int64 `json:"bytes"`
+}
+
+// Empty checks if no orphaned data was found
+func (r GarbageReport) Empty() bool {
+	return len(r.OrphanedFiles) == 0 && len(r.OrphanedRecords) == 0
+}
+
+// CollectGarbage finds the ISO files and blobs in the storage folder without a record, and the records without an ISO
+// file, for example after an apply died during a build. Unless dryRun is set they are deleted. Evicted ISOs are not
+// orphaned, their files are rebuilt on the next access. Files modified within orphanGracePeriod are kept.
+func (s *clientWithStorage) CollectGarbage(dryRun bool) (GarbageReport, error) {
+	report := GarbageReport{
+		StorageFolder:   s.StorageFolder,
+		DryRun:          dryRun,
+		OrphanedFiles:   []string{},
+		OrphanedRecords: []string{},
+	}
+
+	store, err := s.openStore()
+	if err != nil {
+		return report, err
+	}
+
+	isos, err := store.ListIsos()
+	if err != nil {
+		return report, err
+	}
+
+	// the names of the ISO files are compared, the storage folder might be given as another path than before
+	knownFiles := map[string]bool{}
+	knownChecksumFiles := map[string]bool{}
+	knownBlobs := map[string]bool{}
+	for _, iso := range isos {
+		if iso.ObjectURL != "" && s.S3 == nil {
+			// the objects of orphaned records would be left behind in the bucket
+			return report, fmt.Errorf("%w: %s", ErrBucketNotConfigured, iso.ObjectURL)
+		}
+
+		if !iso.Evicted {
+			exists, err := store.IsoFileExists(context.Background(), iso)
+			if err != nil {
+				return report, err
+			}
+
+			if !exists {
+				report.OrphanedRecords = append(report.OrphanedRecords, iso.ID)
+				continue
+			}
+		}
+
+		knownFiles[filepath.Base(iso.LocalPath)] = true
+		knownChecksumFiles[filepath.Base(iso.LocalPath)+checksumFileSuffix] = true
+		knownBlobs[iso.BlobKey] = true
+	}
+
+	var orphanedBlobs []string
+	modifiedBefore := s.now().Add(-orphanGracePeriod)
+	err = s.findOrphanedFiles(s.StorageFolder, ".iso", knownFiles, modifiedBefore, &report, nil)
+	if err == nil {
+		// links of operations that died before renaming them, temporary blobs are orphaned blobs
+		err = s.findOrphanedFiles(s.StorageFolder, tempFileSuffix, knownFiles, modifiedBefore, &report, nil)
+	}
+
+	if err == nil {
+		err = s.findOrphanedFiles(s.StorageFolder, checksumFileSuffix, knownChecksumFiles, modifiedBefore, &report, nil)
+	}
+
+	if err == nil {
+		err = s.findOrphanedFiles(path.Join(s.StorageFolder, blobsFolderName), "", knownBlobs, modifiedBefore, &report, &orphanedBlobs)
+	}
+
+	if err != nil || dryRun {
+		return report, err
+	}
+
+	for _, isoID := range report.OrphanedRecords {
+		// nothing to cancel, the records are removed locally
+		if err := s.DeleteIso(context.Background(), isoID); err != nil {
+			return report, err
+		}
+	}
+
+	for _, filePath := range report.OrphanedFiles {
+		err := os.Remove(filePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			//nolint: errorlint // can't have two errors
+			return report, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+		}
+	}
+
+	// references of builds that died before their record was written, blobs of running builds are not orphaned yet
+	for _, blobKey := range orphanedBlobs {
+		if err := s.dropBlobReferences(store, blobKey); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// findOrphanedFiles adds the files in the folder with the suffix that are not known and were last modified before
+// modifiedBefore to the report
+func (s *clientWithStorage) findOrphanedFiles(folder, suffix string, known map[string]bool, modifiedBefore time.Time, report *GarbageReport, names *[]string) error {
+	entries, err := os.ReadDir(folder)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) || known[entry.Name()] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// removed in the meantime
+			continue
+		}
+
+		if !info.ModTime().Before(modifiedBefore) {
+			// might be built by a concurrent run, which didn't write its record yet
+			continue
+		}
+
+		report.OrphanedFiles = append(report.OrphanedFiles, path.Join(folder, entry.Name()))
+		report.Bytes += info.Size()
+		if names != nil {
+			*names = append(*names, entry.Name())
+		}
+	}
+
+	return nil
+}
+
+// dropBlobReferences resets the reference count of a blob without records
+func (s *clientWithStorage) dropBlobReferences(store Store, blobKey string) error {
+	defer s.lockIso(blobLockPrefix + blobKey)()
+
+	references, err := store.ReferenceBlob(blobKey, 0)
+	if err != nil || references == 0 {
+		return err
+	}
+
+	_, err = store.ReferenceBlob(blobKey, -references)
+	return err
+}
+
+// RunGarbageCollection runs the garbage collection subcommand with the given arguments and returns the exit code.
+// The report is written as JSON to stdout or the file given by -report.
+func RunGarbageCollection(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(GarbageCollectionCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s %s [flags]\n\n", os.Args[0], GarbageCollectionCommand)
+		fmt.Fprintln(stderr, "Removes ISO files without a record and records without an ISO file from the local storage.")
+		fmt.Fprintf(stderr, "Encrypted records are read with the key in %s.\n", EncryptionKeyEnvName)
+		fmt.Fprintf(stderr, "The bucket is accessed with the credentials in %s and %s.\n", S3AccessKeyEnvName, S3SecretKeyEnvName)
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	storageFolder := flags.String("storage", os.Getenv(StorageEnvName),
+		fmt.Sprintf("the local storage of the provider, defaults to %s or the default location", StorageEnvName))
+	backend := flags.String("backend", defaultStorageBackend,
+		fmt.Sprintf("the storage backend, %s or %s", storageBackendBolt, storageBackendDirectory))
+	lockTimeout := flags.Duration("lock-timeout", defaultLockTimeout, "how long to wait for Terraform runs to release the storage")
+	previousEncryptionKeys := flags.String("previous-encryption-keys", "",
+		"comma separated keys the records were encrypted with before, the records are encrypted with the current key like by the provider")
+	s3Endpoint := flags.String("s3-endpoint", "", "the URL of the object storage the ISOs are uploaded to, defaults to the AWS S3 endpoint of the region")
+	s3Region := flags.String("s3-region", defaultS3Region, "the region of the object storage")
+	s3Bucket := flags.String("s3-bucket", "", "the bucket the ISOs are uploaded to, required if they are uploaded")
+	s3Prefix := flags.String("s3-prefix", "", "the prefix of the keys of the uploaded objects")
+	dryRun := flags.Bool("dry-run", false, "only report the orphaned data")
+	reportPath := flags.String("report", "-", "the file the JSON report is written to, - for stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := validateStorageBackend(*backend); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	encryptionKey := os.Getenv(EncryptionKeyEnvName)
+	var previousKeys []string
+	if *previousEncryptionKeys != "" {
+		previousKeys = strings.Split(*previousEncryptionKeys, ",")
+	}
+
+	if _, err := newRecordCodec(encryptionKey, previousKeys); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	var s3Config *S3Config
+	if *s3Bucket != "" {
+		s3Config = &S3Config{
+			Endpoint:  *s3Endpoint,
+			Region:    *s3Region,
+			Bucket:    *s3Bucket,
+			Prefix:    *s3Prefix,
+			AccessKey: os.Getenv(S3AccessKeyEnvName),
+			SecretKey: os.Getenv(S3SecretKeyEnvName),
+		}
+
+		if s3Config.AccessKey == "" || s3Config.SecretKey == "" {
+			fmt.Fprintf(stderr, "the bucket requires the credentials in %s and %s\n", S3AccessKeyEnvName, S3SecretKeyEnvName)
+			return 2
+		}
+	}
+
+	if *storageFolder == "" {
+		*storageFolder = createDefaultStoragePath()
+	}
+
+	c := &clientWithStorage{
+		StorageFolder:          *storageFolder,
+		TimeProvider:           defaultTimeProvider{},
+		LockTimeout:            *lockTimeout,
+		StorageBackend:         *backend,
+		S3:                     s3Config,
+		EncryptionKey:          encryptionKey,
+		PreviousEncryptionKeys: previousKeys,
+	}
+	defer c.Close()
+
+	report, err := c.CollectGarbage(*dryRun)
+	if err != nil {
+		fmt.Fprintln(stderr, "garbage collection failed:", err)
+		return 1
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(stderr, "could not write report:", err)
+		return 1
+	}
+
+	data = append(data, '\n')
+	if *reportPath == "-" {
+		_, err = stdout.Write(data)
+	} else {
+		err = os.WriteFile(*reportPath, data, 0600)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "could not write report:", err)
+		return 1
+	}
+
+	return 0
+}
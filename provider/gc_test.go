@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createGarbage leaves the data of an apply that died during a build and of an ISO whose file was deleted behind
+func createGarbage(t *testing.T, c *clientWithStorage) (orphanedFiles []string) {
+	store, err := c.openStore()
+	assert.NoError(t, err)
+
+	kept, err := c.CreateIso(context.Background(), testIso("kept"))
+	assert.NoError(t, err)
+
+	// a file and a referenced blob without record
+	orphanedIso := path.Join(c.StorageFolder, "died.iso")
+	orphanedBlob := store.BlobPath("0123456789abcdef")
+	assert.NoError(t, os.WriteFile(orphanedBlob, []byte("died"), 0600))
+	assert.NoError(t, os.Link(orphanedBlob, orphanedIso))
+	_, err = store.ReferenceBlob("0123456789abcdef", 1)
+	assert.NoError(t, err)
+
+	// an empty link of an operation that died before renaming it
+	orphanedLink := path.Join(c.StorageFolder, "died.iso.123456"+tempFileSuffix)
+	assert.NoError(t, os.WriteFile(orphanedLink, nil, 0600))
+
+	// a record without file
+	lost := testIso("lost")
+	lost.HostName = "lost"
+	stored, err := c.CreateIso(context.Background(), lost)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(stored.LocalPath))
+
+	// evicted ISOs are no garbage
+	evicted, err := store.ReadIso(context.Background(), kept.ID)
+	assert.NoError(t, err)
+	evicted.ID = "evicted"
+	evicted.LocalPath = path.Join(c.StorageFolder, "evicted.iso")
+	evicted.Evicted = true
+	assert.NoError(t, store.WriteIso(context.Background(), evicted))
+
+	return []string{orphanedIso, orphanedLink, store.BlobPath(stored.BlobKey), orphanedBlob}
+}
+
+// ageFiles moves the modification time of the files before the grace period of the garbage collection
+func ageFiles(t *testing.T, filePaths ...string) {
+	modTime := time.Now().Add(-orphanGracePeriod - time.Minute)
+	for _, filePath := range filePaths {
+		assert.NoError(t, os.Chtimes(filePath, modTime, modTime))
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			c, _ := newTestClient(t)
+			c.StorageBackend = backend
+			c.TimeProvider = &fixedTimeProvider{now: time.Now()}
+			orphanedFiles := createGarbage(t, c)
+			lostBlob := orphanedFiles[2]
+			orphanedFiles = append(orphanedFiles[:2:2], orphanedFiles[3])
+
+			// the files might belong to builds of a concurrent run, which didn't write their records yet
+			report, err := c.CollectGarbage(false)
+			assert.NoError(t, err)
+			assert.Empty(t, report.OrphanedFiles)
+			assert.Equal(t, []string{"lost"}, report.OrphanedRecords)
+			for _, filePath := range orphanedFiles {
+				assert.FileExists(t, filePath)
+			}
+
+			store, err := c.openStore()
+			assert.NoError(t, err)
+			references, err := store.ReferenceBlob("0123456789abcdef", 0)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, references)
+
+			// the record of the lost ISO released its blob
+			assert.NoFileExists(t, lostBlob)
+			ageFiles(t, orphanedFiles...)
+
+			report, err = c.CollectGarbage(true)
+			assert.NoError(t, err)
+			assert.True(t, report.DryRun)
+			assert.ElementsMatch(t, orphanedFiles, report.OrphanedFiles)
+			assert.Empty(t, report.OrphanedRecords)
+			// the hard link and its blob are both counted
+			assert.Equal(t, int64(2*len("died")), report.Bytes)
+
+			// a dry run removes nothing
+			for _, filePath := range orphanedFiles {
+				assert.FileExists(t, filePath)
+			}
+
+			report, err = c.CollectGarbage(false)
+			assert.NoError(t, err)
+			assert.Len(t, report.OrphanedFiles, len(orphanedFiles))
+
+			for _, filePath := range orphanedFiles {
+				assert.NoFileExists(t, filePath)
+			}
+			_, err = c.ReadIso(context.Background(), "lost")
+			assert.ErrorIs(t, err, ErrIsoNotFound)
+
+			references, err = store.ReferenceBlob("0123456789abcdef", 0)
+			assert.NoError(t, err)
+			assert.Zero(t, references)
+
+			kept, err := c.ReadIso(context.Background(), "kept")
+			assert.NoError(t, err)
+			assert.FileExists(t, kept.LocalPath)
+
+			report, err = c.CollectGarbage(false)
+			assert.NoError(t, err)
+			assert.True(t, report.Empty())
+		})
+	}
+}
+
+func TestGarbageCollectionCommand(t *testing.T) {
+	c, _ := newTestClient(t)
+	orphanedFiles := createGarbage(t, c)
+	ageFiles(t, orphanedFiles...)
+	assert.NoError(t, c.Close())
+
+	var stdout, stderr bytes.Buffer
+	reportPath := path.Join(t.TempDir(), "report.json")
+	code := RunGarbageCollection([]string{"-storage", c.StorageFolder, "-dry-run", "-report", reportPath}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Empty(t, stdout.String())
+
+	data, err := os.ReadFile(reportPath)
+	assert.NoError(t, err)
+	var report GarbageReport
+	assert.NoError(t, json.Unmarshal(data, &report))
+	assert.True(t, report.DryRun)
+	assert.Equal(t, c.StorageFolder, report.StorageFolder)
+	assert.ElementsMatch(t, orphanedFiles, report.OrphanedFiles)
+	assert.Equal(t, []string{"lost"}, report.OrphanedRecords)
+
+	code = RunGarbageCollection([]string{"-storage", c.StorageFolder}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.False(t, report.DryRun)
+	for _, filePath := range orphanedFiles {
+		assert.NoFileExists(t, filePath)
+	}
+
+	code = RunGarbageCollection([]string{"-backend", "sqlite"}, &stdout, &stderr)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr.String(), ErrInvalidStorageBackend.Error())
+
+	stderr.Reset()
+	code = RunGarbageCollection([]string{"-h"}, &stdout, &stderr)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr.String(), "Usage: "+os.Args[0]+" "+GarbageCollectionCommand)
+}
+
+func TestGarbageCollectionCommandWithBucket(t *testing.T) {
+	fake, server := newFakeS3(t)
+	config := fake.config(server.URL)
+
+	c, _ := newTestClient(t)
+	c.S3 = &config
+	c.EncryptionKey = testEncryptionKey
+	stored, err := c.CreateIso(context.Background(), testIso("lost"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(stored.LocalPath))
+	assert.NoError(t, c.Close())
+
+	// the records are read with the previous key, during a key rotation
+	t.Setenv(EncryptionKeyEnvName, "")
+	var stdout, stderr bytes.Buffer
+	args := []string{"-storage", c.StorageFolder, "-previous-encryption-keys", testEncryptionKey}
+
+	// the objects of the orphaned record would be left behind
+	code := RunGarbageCollection(args, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), ErrBucketNotConfigured.Error())
+	assert.NotEmpty(t, fake.objects)
+
+	t.Setenv(S3AccessKeyEnvName, config.AccessKey)
+	t.Setenv(S3SecretKeyEnvName, config.SecretKey)
+	args = append(args, "-s3-endpoint", config.Endpoint, "-s3-region", config.Region, "-s3-bucket", config.Bucket, "-s3-prefix", config.Prefix)
+	code = RunGarbageCollection(args, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+
+	var report GarbageReport
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.Equal(t, []string{"lost"}, report.OrphanedRecords)
+	assert.Empty(t, fake.objects)
+
+	// the credentials of the bucket are required
+	t.Setenv(S3SecretKeyEnvName, "")
+	code = RunGarbageCollection(args, &stdout, &stderr)
+	assert.Equal(t, 2, code)
+}
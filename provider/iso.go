@@ -9,6 +9,7 @@ type Iso struct {
 	HostName     string
 	Networks     []Network
 	Optionals    BuildOpts
+	Rebuild      RebuildOpts
 }
 
 type Network struct {
@@ -32,9 +33,39 @@ type BuildOpts struct {
 	Packages        []string `json:"packages" desc:"a list of packages added to the base installation"`
 }
 
+// RebuildOpts control when the ISO file is rebuilt, they do not affect the content of the ISO.
+// Empty values fall back to the provider defaults.
+type RebuildOpts struct {
+	After  string `json:"after,omitempty" desc:"duration after which the ISO expires, e.g. 48h"`
+	Policy string `json:"policy,omitempty" desc:"when an expired ISO is rebuilt: on_read, on_apply or never"`
+}
+
 type StoredIso struct {
 	ID string
 	Iso
 	LocalPath    string
 	CreationTime time.Time
+	// Fingerprint is a hash of the build inputs the ISO file was built with
+	Fingerprint string
+	// AccessTime is the last time the ISO was used. The least recently used ISO files are evicted first.
+	AccessTime time.Time
+	// Evicted is set once the ISO file was evicted from the cache, it is rebuilt by the next apply
+	Evicted bool `json:",omitempty"`
+	// BlobKey is the key of the blob in the content-addressed cache, which LocalPath links to
+	BlobKey string `json:",omitempty"`
+	// Checksums describe the ISO file after its build, they are empty for ISOs stored before checksums existed
+	Checksums IsoChecksums
+	// ObjectURL is the URL of the uploaded ISO file, if an object storage is configured
+	ObjectURL             string `json:",omitempty"`
+	PresignedURL          string `json:",omitempty"`
+	PresignedURLExpiresAt time.Time
+}
+
+// IsoChecksums describe the content of an ISO file
+type IsoChecksums struct {
+	SHA256    string `json:",omitempty"`
+	MD5       string `json:",omitempty"`
+	SizeBytes int64  `json:",omitempty"`
+	// ModTime is the modification time of the file when it was hashed, for verifying it without hashing it again
+	ModTime time.Time
 }
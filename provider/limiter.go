@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	maxConcurrentBuildsKey = "max_concurrent_builds"
+	requestsPerMinuteKey   = "requests_per_minute"
+)
+
+// uiiLimiter throttles the requests to UII of all resources of a provider, so that parallel Terraform operations stay
+// within the rate limits of the UII account. Waiting requests are logged and give up once their context is done.
+type uiiLimiter struct {
+	// builds holds a token per running build, it is nil if the builds are not limited
+	builds              chan struct{}
+	maxConcurrentBuilds int
+	queuedBuilds        int32
+
+	// interval spreads the requests evenly over a minute, it is zero if the requests are not limited
+	interval          time.Duration
+	requestsPerMinute int
+	mutex             sync.Mutex
+	next              time.Time
+}
+
+// newUiiLimiter creates a limiter, zero disables the limit
+func newUiiLimiter(maxConcurrentBuilds, requestsPerMinute int) *uiiLimiter {
+	l := &uiiLimiter{maxConcurrentBuilds: maxConcurrentBuilds, requestsPerMinute: requestsPerMinute}
+	if maxConcurrentBuilds > 0 {
+		l.builds = make(chan struct{}, maxConcurrentBuilds)
+	}
+
+	if requestsPerMinute > 0 {
+		l.interval = time.Minute / time.Duration(requestsPerMinute)
+	}
+
+	return l
+}
+
+// acquireBuild waits for a free build slot. It returns the function to release the slot. The requests of the build
+// are charged to the rate limit by limitedUiiClient, once per attempt.
+func (l *uiiLimiter) acquireBuild(ctx context.Context, request string) (func(), error) {
+	release := func() {}
+	if l.builds != nil {
+		select {
+		case l.builds <- struct{}{}:
+		default:
+			queued := atomic.AddInt32(&l.queuedBuilds, 1)
+			start := time.Now()
+			tflog.Info(ctx, "UII build queued, waiting for a running build to finish", map[string]interface{}{
+				"request":               request,
+				"queued_builds":         queued,
+				"max_concurrent_builds": l.maxConcurrentBuilds,
+			})
+
+			select {
+			case l.builds <- struct{}{}:
+				atomic.AddInt32(&l.queuedBuilds, -1)
+				tflog.Info(ctx, "UII build dequeued", map[string]interface{}{"request": request, "waited": time.Since(start).String()})
+			case <-ctx.Done():
+				atomic.AddInt32(&l.queuedBuilds, -1)
+				return nil, fmt.Errorf("waiting for a build slot for %s: %w", request, ctx.Err())
+			}
+		}
+
+		release = func() { <-l.builds }
+	}
+
+	return release, nil
+}
+
+// waitForRequest waits until the rate limit allows the next request
+func (l *uiiLimiter) waitForRequest(ctx context.Context, request string) error {
+	if l.interval == 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mutex.Unlock()
+
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	tflog.Info(ctx, "UII request delayed by the rate limit", map[string]interface{}{
+		"request":             request,
+		"delay":               delay.String(),
+		"requests_per_minute": l.requestsPerMinute,
+	})
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mutex.Lock()
+		if l.next.Equal(start.Add(l.interval)) {
+			// nobody queued behind this request, its turn can be reused
+			l.next = start
+		}
+		l.mutex.Unlock()
+		return fmt.Errorf("waiting for the rate limit for %s: %w", request, ctx.Err())
+	}
+}
+
+// limitedUiiClient is an IUiiClient that waits for the rate limit before every request. It sits below
+// retryingUiiClient, so that every attempt of a request counts.
+type limitedUiiClient struct {
+	client  IUiiClient
+	limiter *uiiLimiter
+}
+
+// newLimitedUiiClient wraps the client with the rate limit of the limiter
+func newLimitedUiiClient(c IUiiClient, limiter *uiiLimiter) *limitedUiiClient {
+	return &limitedUiiClient{client: c, limiter: limiter}
+}
+
+// Build waits for the rate limit and builds the ISO
+func (c *limitedUiiClient) Build(ctx context.Context, filePath string, args client.BuildArgs, opts client.BuildOpts) error {
+	if err := c.limiter.waitForRequest(ctx, "build "+args.Hostname); err != nil {
+		return err
+	}
+
+	return c.client.Build(ctx, filePath, args, opts)
+}
+
+// OperatingSystems waits for the rate limit and lists the operating systems
+func (c *limitedUiiClient) OperatingSystems(ctx context.Context) ([]client.OS, error) {
+	if err := c.limiter.waitForRequest(ctx, "list operating systems"); err != nil {
+		return nil, err
+	}
+
+	return c.client.OperatingSystems(ctx)
+}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowUiiClient is a fakeUiiClient whose builds take a while, it records how many builds ran at the same time
+type slowUiiClient struct {
+	fakeUiiClient
+	running    int32
+	maxRunning int32
+}
+
+func (c *slowUiiClient) Build(ctx context.Context, filePath string, args client.BuildArgs, opts client.BuildOpts) error {
+	running := atomic.AddInt32(&c.running, 1)
+	defer atomic.AddInt32(&c.running, -1)
+
+	for {
+		maxRunning := atomic.LoadInt32(&c.maxRunning)
+		if running <= maxRunning || atomic.CompareAndSwapInt32(&c.maxRunning, maxRunning, running) {
+			break
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	return c.fakeUiiClient.Build(ctx, filePath, args, opts)
+}
+
+func TestConcurrentBuildsAreLimited(t *testing.T) {
+	c, _ := newTestClient(t)
+	slow := &slowUiiClient{}
+	c.VirtomizeClient = slow
+	c.MaxConcurrentBuilds = 2
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			iso := testIso(fmt.Sprintf("iso_%d", i))
+			iso.HostName = fmt.Sprintf("host%d", i)
+			_, err := c.CreateIso(context.Background(), iso)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, slow.builds, 6)
+	assert.LessOrEqual(t, slow.maxRunning, int32(2))
+}
+
+func TestQueuedBuildTimesOut(t *testing.T) {
+	var logs bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &logs)
+	limiter := newUiiLimiter(1, 0)
+
+	release, err := limiter.acquireBuild(ctx, "build running_iso")
+	assert.NoError(t, err)
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquireBuild(timeout, "build queued_iso")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "queued_iso")
+
+	entries, err := tflogtest.MultilineJSONDecode(&logs)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "UII build queued, waiting for a running build to finish", entries[0]["@message"])
+	assert.Equal(t, "build queued_iso", entries[0]["request"])
+	assert.Equal(t, float64(1), entries[0]["queued_builds"])
+	assert.Equal(t, float64(1), entries[0]["max_concurrent_builds"])
+
+	// the slot is free once the running build finishes
+	release()
+	release, err = limiter.acquireBuild(context.Background(), "build next_iso")
+	assert.NoError(t, err)
+	release()
+}
+
+func TestRequestsAreSpreadOverTheMinute(t *testing.T) {
+	limiter := newUiiLimiter(0, 1200)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, limiter.waitForRequest(context.Background(), "list operating systems"))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// without limits nothing waits
+	unlimited := newUiiLimiter(0, 0)
+	release, err := unlimited.acquireBuild(context.Background(), "build debian_iso")
+	assert.NoError(t, err)
+	release()
+	assert.NoError(t, unlimited.waitForRequest(context.Background(), "list operating systems"))
+}
+
+func TestRateLimitedRequestTimesOut(t *testing.T) {
+	var logs bytes.Buffer
+	limiter := newUiiLimiter(0, 1)
+	c := newLimitedUiiClient(&fakeUiiClient{}, limiter)
+
+	_, err := c.OperatingSystems(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(tflogtest.RootLogger(context.Background(), &logs), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.OperatingSystems(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	entries, err := tflogtest.MultilineJSONDecode(&logs)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "UII request delayed by the rate limit", entries[0]["@message"])
+	assert.Equal(t, "list operating systems", entries[0]["request"])
+	assert.Equal(t, float64(1), entries[0]["requests_per_minute"])
+
+	// the turn of the timed out request is given back
+	limiter.mutex.Lock()
+	next := limiter.next
+	limiter.mutex.Unlock()
+	assert.WithinDuration(t, time.Now().Add(time.Minute), next, 5*time.Second)
+}
+
+func TestEveryRetryIsRateLimited(t *testing.T) {
+	flaky := &flakyUiiClient{failures: 3, err: &uiiStatusError{StatusCode: http.StatusTooManyRequests}}
+	c, delays := newTestRetryingClient(flaky, 4, 30*time.Second)
+	c.client = newLimitedUiiClient(flaky, newUiiLimiter(0, 1200))
+
+	// the backoff is skipped by the test, only the rate limit spaces the attempts
+	start := time.Now()
+	filePath := path.Join(t.TempDir(), "debian.iso")
+	assert.NoError(t, c.Build(context.Background(), filePath, client.BuildArgs{Hostname: "examplehost"}, client.BuildOpts{}))
+	assert.Equal(t, 4, flaky.attempts)
+	assert.Len(t, *delays, 3)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
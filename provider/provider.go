@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
+	"time"
 
-	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
@@ -20,9 +22,53 @@ const TokenEnvName = "VIRTOMIZE_API_TOKEN"
 const StorageEnvName = "VIRTOMIZE_ISO_CACHE"
 const ProviderName = "virtomize"
 
+const lockTimeoutKey = "lock_timeout"
+
 type uiiProviderModel struct {
-	APIToken     types.String `tfsdk:"apitoken"`
-	LocalStorage types.String `tfsdk:"localstorage"`
+	APIToken            types.String  `tfsdk:"apitoken"`
+	Endpoint            types.String  `tfsdk:"endpoint"`
+	ProxyURL            types.String  `tfsdk:"proxy_url"`
+	TLS                 *tlsModel     `tfsdk:"tls"`
+	LocalStorage        types.String  `tfsdk:"localstorage"`
+	OnMissingFile       types.String  `tfsdk:"on_missing_file"`
+	RebuildAfter        types.String  `tfsdk:"rebuild_after"`
+	RebuildPolicy       types.String  `tfsdk:"rebuild_policy"`
+	LockTimeout         types.String  `tfsdk:"lock_timeout"`
+	MaxCacheSize        types.String  `tfsdk:"max_cache_size"`
+	MaxCacheAge         types.String  `tfsdk:"max_cache_age"`
+	CollectGarbage      types.Bool    `tfsdk:"collect_garbage"`
+	VerifyChecksums     types.String  `tfsdk:"verify_checksums"`
+	ChecksumFile        types.Bool    `tfsdk:"checksum_file"`
+	RetryMaxAttempts    types.Int64   `tfsdk:"retry_max_attempts"`
+	RetryMaxBackoff     types.String  `tfsdk:"retry_max_backoff"`
+	MaxConcurrentBuilds types.Int64   `tfsdk:"max_concurrent_builds"`
+	RequestsPerMinute   types.Int64   `tfsdk:"requests_per_minute"`
+	CatalogTTL          types.String  `tfsdk:"catalog_ttl"`
+	RefreshCatalog      types.Bool    `tfsdk:"refresh_catalog"`
+	Storage             *storageModel `tfsdk:"storage"`
+}
+
+type storageModel struct {
+	Backend                types.String   `tfsdk:"backend"`
+	EncryptionKey          types.String   `tfsdk:"encryption_key"`
+	PreviousEncryptionKeys []types.String `tfsdk:"previous_encryption_keys"`
+	S3                     *s3Model       `tfsdk:"s3"`
+}
+
+type tlsModel struct {
+	CABundle          types.String `tfsdk:"ca_bundle"`
+	ClientCertificate types.String `tfsdk:"client_certificate"`
+	ClientKey         types.String `tfsdk:"client_key"`
+}
+
+type s3Model struct {
+	Endpoint             types.String `tfsdk:"endpoint"`
+	Region               types.String `tfsdk:"region"`
+	Bucket               types.String `tfsdk:"bucket"`
+	Prefix               types.String `tfsdk:"prefix"`
+	AccessKey            types.String `tfsdk:"access_key"`
+	SecretKey            types.String `tfsdk:"secret_key"`
+	PresignedURLLifetime types.String `tfsdk:"presigned_url_lifetime"`
 }
 
 // Ensure the implementation satisfies the expected interfaces
@@ -42,7 +88,10 @@ func NewFromVersion(version string) func() provider.Provider {
 }
 
 // uiiProvider is the provider implementation.
-type uiiProvider struct{}
+type uiiProvider struct {
+	// client is kept to release the storage if the provider is configured again
+	client *clientWithStorage
+}
 
 // Metadata returns the provider type name.
 func (p *uiiProvider) Metadata(_ context.Context, _ provider.MetadataRequest, resp *provider.MetadataResponse) {
@@ -61,10 +110,192 @@ func (p *uiiProvider) Schema(_ context.Context, _ provider.SchemaRequest, resp *
 				MarkdownDescription: fmt.Sprintf("The API token for accessing Virtomize UII. If none is provided, the fallback is to use the environment variable `%s`.", TokenEnvName),
 			},
 
+			endpointKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("The URL of the UII API, for example of an on-prem mirror. If none is provided, the fallback is to use the environment variable %q and then %q.", EndpointEnvName, defaultEndpoint),
+				MarkdownDescription: fmt.Sprintf("The URL of the UII API, for example of an on-prem mirror. If none is provided, the fallback is to use the environment variable `%s` and then `%s`.", EndpointEnvName, defaultEndpoint),
+			},
+
+			proxyURLKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "The HTTP proxy for the requests to the UII API, for example \"http://proxy.example.com:3128\". Defaults to the proxy environment variables HTTPS_PROXY, HTTP_PROXY and NO_PROXY.",
+				MarkdownDescription: "The HTTP proxy for the requests to the UII API, for example `http://proxy.example.com:3128`. Defaults to the proxy environment variables `HTTPS_PROXY`, `HTTP_PROXY` and `NO_PROXY`.",
+			},
+
+			retryMaxAttemptsKey: schema.Int64Attribute{
+				Optional:    true,
+				Description: fmt.Sprintf("How often a request to the UII API is sent before giving up. Only transient errors are retried, like server errors, timeouts and reset connections. Defaults to %d.", defaultRetryMaxAttempts),
+			},
+
+			retryMaxBackoffKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("The maximum delay between two attempts of a request to the UII API. The delay starts at %q and doubles with every attempt, a Retry-After of the server longer than this fails the request. Defaults to %q.", initialRetryBackoff, defaultRetryMaxBackoff),
+				MarkdownDescription: fmt.Sprintf("The maximum delay between two attempts of a request to the UII API. The delay starts at `%s` and doubles with every attempt, a `Retry-After` of the server longer than this fails the request. Defaults to `%s`.", initialRetryBackoff, defaultRetryMaxBackoff),
+			},
+
+			maxConcurrentBuildsKey: schema.Int64Attribute{
+				Optional:    true,
+				Description: "The maximum number of ISOs built by UII at the same time, for example to stay within the limits of the UII account when Terraform runs with a high parallelism. Further builds are queued until a build finishes or their timeout passes. Unlimited by default.",
+			},
+
+			requestsPerMinuteKey: schema.Int64Attribute{
+				Optional:    true,
+				Description: "The maximum number of requests to the UII API per minute. The requests are spread evenly over the minute, further requests are delayed until their turn or until their timeout passes. Every attempt counts, including the retries of failed requests. Unlimited by default.",
+			},
+
+			catalogTTLKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("How long the operating systems supported by UII are cached in the local storage, for example \"12h\". The plan validates distribution, version and architecture of new and rebuilt ISOs against the cache without contacting UII. Defaults to %q.", defaultCatalogTTL),
+				MarkdownDescription: fmt.Sprintf("How long the operating systems supported by UII are cached in the local storage, for example `12h`. The plan validates `distribution`, `version` and `architecture` of new and rebuilt ISOs against the cache without contacting UII. Defaults to `%s`.", defaultCatalogTTL),
+			},
+
+			refreshCatalogKey: schema.BoolAttribute{
+				Optional:    true,
+				Description: "Fetch the operating systems supported by UII once per run even if they are cached, for example after UII added a distribution. Defaults to false.",
+			},
+
 			"localstorage": schema.StringAttribute{
 				Optional:    true,
 				Description: "The provider will store some data locally to work correctly. Use this parameter to overwrite the default location.",
 			},
+
+			onMissingFileKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("What to do if a cached ISO file was removed from disk: %q recreates it during refresh, %q removes the resource from the state so that the next apply creates it again. Defaults to %q.", missingFileRebuild, missingFileRecreate, missingFileRecreate),
+				MarkdownDescription: fmt.Sprintf("What to do if a cached ISO file was removed from disk: `%s` recreates it during refresh, `%s` removes the resource from the state so that the next apply creates it again. Defaults to `%s`.", missingFileRebuild, missingFileRecreate, missingFileRecreate),
+			},
+
+			rebuildAfterKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("The default duration after which ISOs expire and are rebuilt. Defaults to %q.", defaultRebuildAfter),
+				MarkdownDescription: fmt.Sprintf("The default duration after which ISOs expire and are rebuilt. Defaults to `%s`.", defaultRebuildAfter),
+			},
+
+			rebuildPolicyKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("The default policy for rebuilding expired ISOs: %q during refresh, %q by planning an update, %q lets ISOs never expire. Defaults to %q.", rebuildOnRead, rebuildOnApply, rebuildNever, defaultRebuildPolicy),
+				MarkdownDescription: fmt.Sprintf("The default policy for rebuilding expired ISOs: `%s` during refresh, `%s` by planning an update, `%s` lets ISOs never expire. Defaults to `%s`.", rebuildOnRead, rebuildOnApply, rebuildNever, defaultRebuildPolicy),
+			},
+
+			lockTimeoutKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("How long to wait for another process, for example a parallel Terraform run sharing the local storage, to release the ISO cache. Defaults to %q.", defaultLockTimeout),
+				MarkdownDescription: fmt.Sprintf("How long to wait for another process, for example a parallel Terraform run sharing the local storage, to release the ISO cache. Defaults to `%s`.", defaultLockTimeout),
+			},
+
+			maxCacheSizeKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "The maximum size of the ISO files in the local storage, for example \"20GiB\". The least recently used ISO files are evicted first. Unlimited by default.",
+				MarkdownDescription: "The maximum size of the ISO files in the local storage, for example `20GiB`. The least recently used ISO files are evicted first. Unlimited by default.",
+			},
+
+			maxCacheAgeKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "The duration after which unused ISO files are evicted from the local storage, for example \"168h\". Unlimited by default.",
+				MarkdownDescription: "The duration after which unused ISO files are evicted from the local storage, for example `168h`. Unlimited by default.",
+			},
+
+			collectGarbageKey: schema.BoolAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("If true, ISO files without a record and records without an ISO file are removed from the local storage when the provider is configured. The %q subcommand of the provider binary does the same outside of Terraform and can report without removing anything. Files modified within the last %q are kept, they might belong to a build of a concurrent run.", GarbageCollectionCommand, orphanGracePeriod),
+				MarkdownDescription: fmt.Sprintf("If true, ISO files without a record and records without an ISO file are removed from the local storage when the provider is configured. The `%s` subcommand of the provider binary does the same outside of Terraform and can report without removing anything. Files modified within the last `%s` are kept, they might belong to a build of a concurrent run.", GarbageCollectionCommand, orphanGracePeriod),
+			},
+
+			verifyChecksumsKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("How ISO files are verified against their checksums during refresh: %q hashes the whole file, %q compares its size and modification time, %q disables the verification. Corrupt ISO files are handled like missing ones, see %q. Defaults to %q.", verifyChecksumsFull, verifyChecksumsQuick, verifyChecksumsOff, onMissingFileKey, defaultVerifyChecksums),
+				MarkdownDescription: fmt.Sprintf("How ISO files are verified against their checksums during refresh: `%s` hashes the whole file, `%s` compares its size and modification time, `%s` disables the verification. Corrupt ISO files are handled like missing ones, see `%s`. Defaults to `%s`.", verifyChecksumsFull, verifyChecksumsQuick, verifyChecksumsOff, onMissingFileKey, defaultVerifyChecksums),
+			},
+
+			checksumFileKey: schema.BoolAttribute{
+				Optional:            true,
+				Description:         fmt.Sprintf("If true, the SHA-256 checksum of every ISO is written next to it into a file with the suffix %q, in the format of sha256sum.", checksumFileSuffix),
+				MarkdownDescription: fmt.Sprintf("If true, the SHA-256 checksum of every ISO is written next to it into a file with the suffix `%s`, in the format of `sha256sum`.", checksumFileSuffix),
+			},
+		},
+
+		Blocks: map[string]schema.Block{
+			tlsKey: schema.SingleNestedBlock{
+				Description: "TLS settings for the connection to the UII API. The files are PEM encoded.",
+				Attributes: map[string]schema.Attribute{
+					caBundleKey: schema.StringAttribute{
+						Optional:    true,
+						Description: "The path of a file with certificates that are trusted in addition to the system certificates, for example the CA of an on-prem mirror.",
+					},
+					clientCertificateKey: schema.StringAttribute{
+						Optional:            true,
+						Description:         "The path of the client certificate for servers requiring mutual TLS. Requires \"" + clientKeyKey + "\".",
+						MarkdownDescription: "The path of the client certificate for servers requiring mutual TLS. Requires `" + clientKeyKey + "`.",
+					},
+					clientKeyKey: schema.StringAttribute{
+						Optional:    true,
+						Description: "The path of the private key of the client certificate.",
+					},
+				},
+			},
+			storageKey: schema.SingleNestedBlock{
+				Description: "Selects where the provider keeps the records of the built ISOs.",
+				Attributes: map[string]schema.Attribute{
+					storageBackendKey: schema.StringAttribute{
+						Optional:            true,
+						Description:         fmt.Sprintf("The storage backend: %q keeps all records in a database in the local storage, %q keeps every record in its own JSON file, which needs no file lock and suits CI runs. Defaults to %q.", storageBackendBolt, storageBackendDirectory, defaultStorageBackend),
+						MarkdownDescription: fmt.Sprintf("The storage backend: `%s` keeps all records in a database in the local storage, `%s` keeps every record in its own JSON file, which needs no file lock and suits CI runs. Defaults to `%s`.", storageBackendBolt, storageBackendDirectory, defaultStorageBackend),
+					},
+					encryptionKeyKey: schema.StringAttribute{
+						Optional:            true,
+						Sensitive:           true,
+						Description:         fmt.Sprintf("A base64 encoded AES key of 16, 24 or 32 bytes, for example created with \"openssl rand -base64 32\". The records of the ISOs, which contain the password hashes, SSH keys and networks, are encrypted with AES-GCM. Existing records are encrypted when the provider is configured. If none is provided, the fallback is to use the environment variable %q.", EncryptionKeyEnvName),
+						MarkdownDescription: fmt.Sprintf("A base64 encoded AES key of 16, 24 or 32 bytes, for example created with `openssl rand -base64 32`. The records of the ISOs, which contain the password hashes, SSH keys and networks, are encrypted with AES-GCM. Existing records are encrypted when the provider is configured. If none is provided, the fallback is to use the environment variable `%s`.", EncryptionKeyEnvName),
+					},
+					previousEncryptionKeyKey: schema.ListAttribute{
+						ElementType:         types.StringType,
+						Optional:            true,
+						Sensitive:           true,
+						Description:         fmt.Sprintf("Keys the records were encrypted with before. To rotate the key, move the old key here and set a new %q, the records are encrypted with the new key when the provider is configured. Without %q the records are decrypted.", encryptionKeyKey, encryptionKeyKey),
+						MarkdownDescription: fmt.Sprintf("Keys the records were encrypted with before. To rotate the key, move the old key here and set a new `%s`, the records are encrypted with the new key when the provider is configured. Without `%s` the records are decrypted.", encryptionKeyKey, encryptionKeyKey),
+					},
+				},
+				Blocks: map[string]schema.Block{
+					s3Key: schema.SingleNestedBlock{
+						Description: "Uploads every built ISO and its record to a bucket of an S3 compatible object storage. Objects are addressed path-style, i.e. <endpoint>/<bucket>/<key>.",
+						Attributes: map[string]schema.Attribute{
+							s3EndpointKey: schema.StringAttribute{
+								Optional:    true,
+								Description: "The URL of the object storage. Defaults to the AWS S3 endpoint of the region.",
+							},
+							s3RegionKey: schema.StringAttribute{
+								Optional:            true,
+								Description:         fmt.Sprintf("The region used for signing requests. Defaults to %q.", defaultS3Region),
+								MarkdownDescription: fmt.Sprintf("The region used for signing requests. Defaults to `%s`.", defaultS3Region),
+							},
+							s3BucketKey: schema.StringAttribute{
+								Optional:    true,
+								Description: "The bucket the ISOs are uploaded to. Required if the block is set.",
+							},
+							s3PrefixKey: schema.StringAttribute{
+								Optional:    true,
+								Description: "A prefix for the keys of the uploaded objects, for example \"isos/\".",
+							},
+							s3AccessKeyKey: schema.StringAttribute{
+								Optional:            true,
+								Description:         fmt.Sprintf("The access key. If none is provided, the fallback is to use the environment variable %q.", S3AccessKeyEnvName),
+								MarkdownDescription: fmt.Sprintf("The access key. If none is provided, the fallback is to use the environment variable `%s`.", S3AccessKeyEnvName),
+							},
+							s3SecretKeyKey: schema.StringAttribute{
+								Optional:            true,
+								Sensitive:           true,
+								Description:         fmt.Sprintf("The secret key. If none is provided, the fallback is to use the environment variable %q.", S3SecretKeyEnvName),
+								MarkdownDescription: fmt.Sprintf("The secret key. If none is provided, the fallback is to use the environment variable `%s`.", S3SecretKeyEnvName),
+							},
+							presignedURLLifetimeKey: schema.StringAttribute{
+								Optional:            true,
+								Description:         fmt.Sprintf("How long the presigned URLs of the uploaded ISOs are valid, at most %q. They are renewed during refresh once half of the lifetime has passed. Defaults to %q.", maxPresignedURLLifetime, defaultPresignedURLLifetime),
+								MarkdownDescription: fmt.Sprintf("How long the presigned URLs of the uploaded ISOs are valid, at most `%s`. They are renewed during refresh once half of the lifetime has passed. Defaults to `%s`.", maxPresignedURLLifetime, defaultPresignedURLLifetime),
+							},
+						},
+					},
+				},
+			},
 		},
 	}
 }
@@ -112,16 +343,229 @@ func (p *uiiProvider) Configure(ctx context.Context, req provider.ConfigureReque
 			"Local folder does not exist")
 	}
 
-	c, err := client.NewClient(token)
+	// missing file policy
+	missingFilePolicy := stringOrDefault(config.OnMissingFile, "")
+	if err := validateMissingFilePolicy(missingFilePolicy); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(onMissingFileKey), "Invalid missing file policy", err.Error())
+		return
+	}
+
+	if missingFilePolicy == "" || missingFilePolicy == unknownString {
+		missingFilePolicy = missingFileRecreate
+	}
+
+	// rebuild settings
+	rebuildAfter := stringOrDefault(config.RebuildAfter, "")
+	if err := validateDuration(rebuildAfter, rebuildAfterKey); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(rebuildAfterKey), "Invalid rebuild duration", err.Error())
+		return
+	}
+
+	rebuildAfterDuration := defaultRebuildAfter
+	if rebuildAfter != "" && rebuildAfter != unknownString {
+		rebuildAfterDuration, _ = time.ParseDuration(rebuildAfter)
+	}
+
+	rebuildPolicy := stringOrDefault(config.RebuildPolicy, "")
+	if err := validateRebuildPolicy(rebuildPolicy); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(rebuildPolicyKey), "Invalid rebuild policy", err.Error())
+		return
+	}
+
+	if rebuildPolicy == "" || rebuildPolicy == unknownString {
+		rebuildPolicy = defaultRebuildPolicy
+	}
+
+	// lock timeout
+	lockTimeout := stringOrDefault(config.LockTimeout, "")
+	if err := validateDuration(lockTimeout, lockTimeoutKey); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(lockTimeoutKey), "Invalid lock timeout", err.Error())
+		return
+	}
+
+	lockTimeoutDuration := defaultLockTimeout
+	if lockTimeout != "" && lockTimeout != unknownString {
+		lockTimeoutDuration, _ = time.ParseDuration(lockTimeout)
+	}
+
+	// cache limits
+	maxCacheSize := stringOrDefault(config.MaxCacheSize, "")
+	if err := validateCacheSize(maxCacheSize); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(maxCacheSizeKey), "Invalid maximum cache size", err.Error())
+		return
+	}
+
+	var maxCacheSizeBytes int64
+	if maxCacheSize != "" && maxCacheSize != unknownString {
+		maxCacheSizeBytes, _ = parseByteSize(maxCacheSize)
+	}
+
+	maxCacheAge := stringOrDefault(config.MaxCacheAge, "")
+	if err := validateDuration(maxCacheAge, maxCacheAgeKey); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(maxCacheAgeKey), "Invalid maximum cache age", err.Error())
+		return
+	}
+
+	var maxCacheAgeDuration time.Duration
+	if maxCacheAge != "" && maxCacheAge != unknownString {
+		maxCacheAgeDuration, _ = time.ParseDuration(maxCacheAge)
+	}
+
+	// checksums
+	verifyChecksums := stringOrDefault(config.VerifyChecksums, "")
+	if err := validateVerifyChecksums(verifyChecksums); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(verifyChecksumsKey), "Invalid checksum verification", err.Error())
+		return
+	}
+
+	if verifyChecksums == "" || verifyChecksums == unknownString {
+		verifyChecksums = defaultVerifyChecksums
+	}
+
+	// storage backend
+	storageBackend := defaultStorageBackend
+	if config.Storage != nil {
+		backend := stringOrDefault(config.Storage.Backend, "")
+		if err := validateStorageBackend(backend); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root(storageKey).AtName(storageBackendKey), "Invalid storage backend", err.Error())
+			return
+		}
+
+		if backend != "" && backend != unknownString {
+			storageBackend = backend
+		}
+	}
+
+	// encryption
+	encryptionKey := os.Getenv(EncryptionKeyEnvName)
+	var previousEncryptionKeys []string
+	if config.Storage != nil {
+		encryptionKey = configString(config.Storage.EncryptionKey, encryptionKey)
+		for _, key := range config.Storage.PreviousEncryptionKeys {
+			previousEncryptionKeys = append(previousEncryptionKeys, configString(key, ""))
+		}
+	}
+
+	if _, err := newRecordCodec(encryptionKey, previousEncryptionKeys); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(storageKey).AtName(encryptionKeyKey), "Invalid encryption key", err.Error())
+		return
+	}
+
+	var s3Config *S3Config
+	if config.Storage != nil && config.Storage.S3 != nil {
+		s3Config = s3ConfigFromModel(*config.Storage.S3, &resp.Diagnostics)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	// UII endpoint
+	endpointConfig := EndpointConfig{
+		URL:      configString(config.Endpoint, os.Getenv(EndpointEnvName)),
+		ProxyURL: configString(config.ProxyURL, ""),
+	}
+
+	if config.TLS != nil {
+		endpointConfig.CABundle = configString(config.TLS.CABundle, "")
+		endpointConfig.ClientCertificate = configString(config.TLS.ClientCertificate, "")
+		endpointConfig.ClientKey = configString(config.TLS.ClientKey, "")
+	}
+
+	c, err := newUiiHTTPClient(token, endpointConfig)
 	if err != nil {
-		resp.Diagnostics.AddError(
-			"Unable to create Virtomize client",
-			"Unable to authenticate user for authenticated Virtomize client")
+		resp.Diagnostics.AddError("Unable to create Virtomize client", err.Error())
+		return
+	}
+
+	// retries
+	if err := validateRetryMaxAttempts(config.RetryMaxAttempts); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(retryMaxAttemptsKey), "Invalid retry attempts", err.Error())
+		return
+	}
+
+	retryMaxBackoff := stringOrDefault(config.RetryMaxBackoff, "")
+	if err := validateDuration(retryMaxBackoff, retryMaxBackoffKey); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(retryMaxBackoffKey), "Invalid retry backoff", err.Error())
+		return
+	}
+
+	retryMaxBackoffDuration := defaultRetryMaxBackoff
+	if retryMaxBackoff != "" && retryMaxBackoff != unknownString {
+		retryMaxBackoffDuration, _ = time.ParseDuration(retryMaxBackoff)
+	}
+
+	// request limits
+	if err := validateLimit(config.MaxConcurrentBuilds, maxConcurrentBuildsKey); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(maxConcurrentBuildsKey), "Invalid build limit", err.Error())
+		return
+	}
 
+	if err := validateLimit(config.RequestsPerMinute, requestsPerMinuteKey); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(requestsPerMinuteKey), "Invalid request limit", err.Error())
 		return
 	}
 
-	client := &clientWithStorage{VirtomizeClient: c, StorageFolder: localPath, TimeProvider: defaultTimeProvider{}}
+	// operating systems catalog
+	catalogTTL := stringOrDefault(config.CatalogTTL, "")
+	if err := validateDuration(catalogTTL, catalogTTLKey); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root(catalogTTLKey), "Invalid catalog TTL", err.Error())
+		return
+	}
+
+	catalogTTLDuration := defaultCatalogTTL
+	if catalogTTL != "" && catalogTTL != unknownString {
+		catalogTTLDuration, _ = time.ParseDuration(catalogTTL)
+	}
+
+	// the limiter sits below the retries, so that every attempt is charged to the rate limit
+	limiter := newUiiLimiter(int(config.MaxConcurrentBuilds.ValueInt64()), int(config.RequestsPerMinute.ValueInt64()))
+	limited := newLimitedUiiClient(c, limiter)
+
+	client := &clientWithStorage{
+		VirtomizeClient:     newRetryingUiiClient(limited, int(config.RetryMaxAttempts.ValueInt64()), retryMaxBackoffDuration),
+		StorageFolder:       localPath,
+		TimeProvider:        defaultTimeProvider{},
+		MissingFilePolicy:   missingFilePolicy,
+		RebuildAfter:        rebuildAfterDuration,
+		RebuildPolicy:       rebuildPolicy,
+		LockTimeout:         lockTimeoutDuration,
+		StorageBackend:      storageBackend,
+		S3:                  s3Config,
+		MaxCacheSize:        maxCacheSizeBytes,
+		MaxCacheAge:         maxCacheAgeDuration,
+		VerifyChecksums:     verifyChecksums,
+		ChecksumFile:        config.ChecksumFile.ValueBool(),
+		MaxConcurrentBuilds: int(config.MaxConcurrentBuilds.ValueInt64()),
+		limiter:             limiter,
+		CatalogTTL:          catalogTTLDuration,
+		RefreshCatalog:      config.RefreshCatalog.ValueBool(),
+
+		EncryptionKey:          encryptionKey,
+		PreviousEncryptionKeys: previousEncryptionKeys,
+	}
+
+	if p.client != nil {
+		_ = p.client.Close()
+	}
+	p.client = client
+
+	if config.CollectGarbage.ValueBool() {
+		report, err := client.CollectGarbage(false)
+		if err != nil {
+			resp.Diagnostics.AddWarning("Could not collect garbage", "Orphaned ISO files and records could not be removed from "+localPath+": "+err.Error())
+		} else if !report.Empty() {
+			resp.Diagnostics.AddWarning("Removed orphaned ISO data",
+				fmt.Sprintf("Removed %d files without record (%d bytes) and %d records without ISO file from %s.",
+					len(report.OrphanedFiles), report.Bytes, len(report.OrphanedRecords), localPath))
+		}
+	}
+
+	if client.RefreshCatalog {
+		// fetched here, so that the plan validates the ISOs against the refreshed catalog
+		if _, err := client.ReadDistributions(ctx); err != nil {
+			resp.Diagnostics.AddWarning("Could not refresh the operating systems", "The operating systems supported by UII could not be fetched, the ISOs are validated once they are created: "+err.Error())
+		}
+	}
 
 	// Make the client available during DataSource and Resource
 	// type Configure methods.
@@ -131,7 +575,10 @@ func (p *uiiProvider) Configure(ctx context.Context, req provider.ConfigureReque
 
 // DataSources defines the data sources implemented in the provider.
 func (p *uiiProvider) DataSources(_ context.Context) []func() datasource.DataSource {
-	return nil
+	return []func() datasource.DataSource{
+		NewOperatingSystemsDataSource,
+		NewDistributionDataSource,
+	}
 }
 
 // Resources defines the resources implemented in the provider.
@@ -141,8 +588,56 @@ func (p *uiiProvider) Resources(_ context.Context) []func() resource.Resource {
 	}
 }
 
+// s3ConfigFromModel validates the s3 block and applies the defaults and environment variables
+func s3ConfigFromModel(model s3Model, diags *diag.Diagnostics) *S3Config {
+	s3Path := path.Root(storageKey).AtName(s3Key)
+
+	config := &S3Config{
+		Endpoint:  configString(model.Endpoint, ""),
+		Region:    configString(model.Region, defaultS3Region),
+		Bucket:    configString(model.Bucket, ""),
+		Prefix:    configString(model.Prefix, ""),
+		AccessKey: configString(model.AccessKey, os.Getenv(S3AccessKeyEnvName)),
+		SecretKey: configString(model.SecretKey, os.Getenv(S3SecretKeyEnvName)),
+	}
+
+	if config.Endpoint != "" {
+		if _, err := newS3Client(config.Endpoint, "bucket", sigV4Credentials{}); err != nil {
+			diags.AddAttributeError(s3Path.AtName(s3EndpointKey), "Invalid S3 endpoint", err.Error())
+		}
+	}
+
+	if config.Bucket == "" {
+		diags.AddAttributeError(s3Path.AtName(s3BucketKey), "Missing S3 bucket", "The bucket is required to upload ISOs to an object storage.")
+	}
+
+	if config.AccessKey == "" || config.SecretKey == "" {
+		diags.AddAttributeError(s3Path, "Missing S3 credentials",
+			fmt.Sprintf("Set %q and %q or use the environment variables %s and %s.", s3AccessKeyKey, s3SecretKeyKey, S3AccessKeyEnvName, S3SecretKeyEnvName))
+	}
+
+	lifetime := configString(model.PresignedURLLifetime, "")
+	if err := validatePresignedURLLifetime(lifetime); err != nil {
+		diags.AddAttributeError(s3Path.AtName(presignedURLLifetimeKey), "Invalid presigned URL lifetime", err.Error())
+	} else if lifetime != "" {
+		config.PresignedURLLifetime, _ = time.ParseDuration(lifetime)
+	}
+
+	return config
+}
+
+// configString returns the configured value, or the fallback if the value is not set or not known yet
+func configString(value types.String, fallback string) string {
+	if value.IsNull() || value.IsUnknown() {
+		return fallback
+	}
+
+	return value.ValueString()
+}
+
 func createDefaultStoragePath() string {
-	defaultStoragePath := path.Join(os.TempDir(), "uiiterraform")
-	_ = os.Mkdir(defaultStoragePath, os.ModePerm)
+	defaultStoragePath := filepath.Join(os.TempDir(), "uiiterraform")
+	// the records in the storage might contain secrets, the mode of existing folders is restricted when the store is opened
+	_ = os.Mkdir(defaultStoragePath, 0700)
 	return defaultStoragePath
 }
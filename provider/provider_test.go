@@ -3,12 +3,17 @@ package provider
 import (
 	"errors"
 	"fmt"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 var testAccProtoV6ProviderFactories = map[string]func() (tfprotov6.ProviderServer, error){
@@ -127,6 +132,117 @@ resource "virtomize_iso" "debian_iso" {
 	})
 }
 
+func TestOperatingSystemsDataSource(t *testing.T) {
+	testConfiguration := `
+provider "virtomize" {
+}
+
+data "virtomize_operating_systems" "debian" {
+    distribution = "debian"
+}`
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testConfiguration,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrSet("data.virtomize_operating_systems.debian", "operating_systems.#"),
+					resource.TestCheckResourceAttr("data.virtomize_operating_systems.debian", "operating_systems.0.distribution", "debian"),
+				),
+			},
+		},
+	})
+}
+
+func TestIsoLifeCycleAgainstStandIn(t *testing.T) {
+	standIn, server := newUiiStandIn(t)
+
+	testConfiguration := fmt.Sprintf(`
+provider "virtomize" {
+  apitoken = %q
+  endpoint = %q
+  localstorage = %q
+}
+
+data "virtomize_operating_systems" "debian" {
+    distribution = "debian"
+}
+
+resource "virtomize_iso" "debian_iso" {
+    name = "debian_iso"
+    distribution = "debian"
+    version = "11"
+    hostname = "examplehost"
+    networks = [{
+      dhcp = true
+      no_internet = false
+    }]
+ }`, standInToken, server.URL+"/uii", t.TempDir())
+
+	// no API token is needed, the stand-in replaces the live service
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testConfiguration,
+				Check: resource.ComposeTestCheckFunc(
+					checkSimpleIsoProperties,
+					resource.TestCheckResourceAttr("data.virtomize_operating_systems.debian", "operating_systems.0.distribution", "debian"),
+					resource.TestCheckResourceAttr("virtomize_iso.debian_iso", sizeBytesKey, fmt.Sprint(len("iso for examplehost"))),
+					func(*terraform.State) error {
+						standIn.mu.Lock()
+						defer standIn.mu.Unlock()
+						if len(standIn.builds) != 1 {
+							return fmt.Errorf("expected one build, got %d", len(standIn.builds))
+						}
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestS3ConfigFromModel(t *testing.T) {
+	t.Setenv(S3AccessKeyEnvName, "env-access")
+	t.Setenv(S3SecretKeyEnvName, "env-secret")
+
+	var diags diag.Diagnostics
+	config := s3ConfigFromModel(s3Model{
+		Bucket:               types.StringValue("isos"),
+		PresignedURLLifetime: types.StringValue("2h"),
+	}, &diags)
+	assert.False(t, diags.HasError(), diags)
+	assert.Equal(t, &S3Config{
+		Region:               defaultS3Region,
+		Bucket:               "isos",
+		AccessKey:            "env-access",
+		SecretKey:            "env-secret",
+		PresignedURLLifetime: 2 * time.Hour,
+	}, config)
+
+	config = s3ConfigFromModel(s3Model{
+		Endpoint:  types.StringValue("http://localhost:9000"),
+		Region:    types.StringValue("eu-central-1"),
+		Bucket:    types.StringValue("isos"),
+		Prefix:    types.StringValue("terraform/"),
+		AccessKey: types.StringValue("access"),
+		SecretKey: types.StringValue("secret"),
+	}, &diags)
+	assert.False(t, diags.HasError(), diags)
+	assert.Equal(t, "http://localhost:9000", config.Endpoint)
+	assert.Equal(t, "access", config.AccessKey)
+
+	t.Setenv(S3SecretKeyEnvName, "")
+	s3ConfigFromModel(s3Model{
+		Endpoint:             types.StringValue("localhost"),
+		PresignedURLLifetime: types.StringValue("30d"),
+	}, &diags)
+	assert.Equal(t, 4, diags.ErrorsCount())
+}
+
 func checkSimpleIsoProperties(state *terraform.State) error {
 	resource_name := "virtomize_iso.debian_iso"
 	rs, ok := state.RootModule().Resources[resource_name]
@@ -2,10 +2,15 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 
@@ -14,7 +19,10 @@ import (
 
 // Ensure the implementation satisfies the expected interfaces.
 var (
-	_ resource.Resource = &IsoResource{}
+	_ resource.Resource                = &IsoResource{}
+	_ resource.ResourceWithImportState = &IsoResource{}
+	_ resource.ResourceWithModifyPlan  = &IsoResource{}
+	_ resource.ResourceWithConfigure   = &IsoResource{}
 )
 
 const (
@@ -75,14 +83,11 @@ func (r *IsoResource) Create(ctx context.Context, req resource.CreateRequest, re
 		return
 	}
 
-	distributions, err := r.client.ReadDistributions()
-	if err != nil {
-		// fallback to allowing everything, to support terraform plan for users that have not created an api key yet
-		// not sure about this
-		distributions = []client.OS{}
-	}
+	ctx, cancel := plan.Timeouts.createContext(ctx)
+	defer cancel()
 
-	errors := validateIso(plan, distributions)
+	// the operating system was validated against the cached catalog by ModifyPlan
+	errors := validateIso(plan, nil)
 	if errors != nil {
 		for _, e := range errors {
 			resp.Diagnostics.AddError("Error validating iso", e.Error())
@@ -92,9 +97,9 @@ func (r *IsoResource) Create(ctx context.Context, req resource.CreateRequest, re
 
 	iso := parseIsoFromResourceModel(plan)
 
-	storedIso, err := r.client.CreateIso(iso)
+	storedIso, err := r.client.CreateIso(ctx, iso)
 	if err != nil {
-		resp.Diagnostics.AddError("Error creating iso", err.Error())
+		addIsoError(&resp.Diagnostics, "Error creating iso", "Could not create ISO "+iso.Name+".", err)
 		return
 	}
 
@@ -102,6 +107,11 @@ func (r *IsoResource) Create(ctx context.Context, req resource.CreateRequest, re
 	plan.ID = types.StringValue(storedIso.ID)
 	plan.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
 	plan.LocalPath = types.StringValue(storedIso.LocalPath)
+	plan.ExpiresAt = r.expiresAtToModel(storedIso)
+	plan.Fingerprint = types.StringValue(storedIso.Fingerprint)
+	plan.ObjectURL = optionalStringToModel(storedIso.ObjectURL)
+	plan.PresignedURL = optionalStringToModel(storedIso.PresignedURL)
+	setChecksumsToModel(storedIso.Checksums, &plan)
 
 	// Set state to fully populated data
 	resp.Diagnostics.Append(resp.State.Set(ctx, plan)...)
@@ -118,7 +128,7 @@ func (r IsoResource) ValidateConfig(ctx context.Context, req resource.ValidateCo
 		return
 	}
 
-	// fallback to allowing everything, as no client is present during the plan phase
+	// the operating systems are validated against the cached catalog by ModifyPlan, which knows if they changed
 	var distributions []client.OS
 
 	errors := validateIso(data, distributions)
@@ -145,17 +155,36 @@ func (r *IsoResource) Read(ctx context.Context, req resource.ReadRequest, resp *
 		return
 	}
 
-	iso, err := r.client.ReadIso(state.ID.ValueString())
-	if err != nil {
-		resp.Diagnostics.AddError(
-			"Error reading ISO from storage",
-			"Could not read ISO Id "+state.ID.ValueString()+". Try removing it from state file to force regeneration. Error was: "+err.Error(),
+	ctx, cancel := state.Timeouts.readContext(ctx)
+	defer cancel()
+
+	iso, err := r.client.ReadIso(ctx, state.ID.ValueString())
+	if errors.Is(err, ErrIsoNotFound) {
+		resp.Diagnostics.AddWarning(
+			"ISO not found",
+			"The ISO Id "+state.ID.ValueString()+" does not exist in the local storage anymore. The resource was removed from the state and will be recreated on the next apply.",
 		)
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	if err != nil {
+		addIsoError(&resp.Diagnostics, "Error reading ISO from storage", "Could not read ISO Id "+state.ID.ValueString()+".", err)
 		return
 	}
 
+	// evicted files are rebuilt by the next apply, see ModifyPlan
+	if !iso.Evicted {
+		var ok bool
+		iso, ok = r.refreshIsoFile(ctx, state, iso, resp)
+		if !ok {
+			return
+		}
+	}
+
 	// Overwrite items with refreshed state
 	setIsoToModel(iso, &state)
+	state.ExpiresAt = r.expiresAtToModel(iso)
 
 	// Set refreshed state
 	diags = resp.State.Set(ctx, &state)
@@ -165,6 +194,345 @@ func (r *IsoResource) Read(ctx context.Context, req resource.ReadRequest, resp *
 	}
 }
 
+// refreshIsoFile handles a missing or corrupt ISO file according to the missing file policy. It returns false if the
+// resource was removed from the state or the file could not be checked.
+func (r *IsoResource) refreshIsoFile(ctx context.Context, state isoResourceModel, iso StoredIso, resp *resource.ReadResponse) (StoredIso, bool) {
+	exists, err := r.client.IsoFileExists(ctx, iso)
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root(localPathKey),
+			"Error checking ISO file",
+			"Could not check ISO file "+iso.LocalPath+": "+err.Error(),
+		)
+		return iso, false
+	}
+
+	// a corrupt file is handled like a missing one, either way the file differs from the state
+	var problem, detail string
+	if !exists {
+		problem, detail = "ISO file is missing", "The ISO file "+iso.LocalPath+" does not exist anymore."
+	} else if err := r.client.VerifyIsoFile(iso); errors.Is(err, ErrIsoFileCorrupt) {
+		problem, detail = "ISO file is corrupt", "The ISO file "+iso.LocalPath+" was changed after its build, "+err.Error()+"."
+	} else if err != nil {
+		addIsoError(&resp.Diagnostics, "Error verifying ISO file", "Could not verify the ISO file of ISO Id "+state.ID.ValueString()+".", err)
+		return iso, false
+	}
+
+	if problem != "" {
+		policy := r.client.MissingFilePolicy
+		if !state.OnMissingFile.IsNull() && !state.OnMissingFile.IsUnknown() {
+			policy = state.OnMissingFile.ValueString()
+		}
+
+		if policy != missingFileRebuild {
+			resp.Diagnostics.AddAttributeWarning(
+				path.Root(localPathKey),
+				problem,
+				detail+" The resource was removed from the state and will be recreated on the next apply.",
+			)
+			resp.State.RemoveResource(ctx)
+			return iso, false
+		}
+
+		resp.Diagnostics.AddAttributeWarning(path.Root(localPathKey), problem, detail+" The ISO was rebuilt.")
+
+		if exists {
+			iso, err = r.client.RepairIso(ctx, state.ID.ValueString())
+		} else {
+			iso, err = r.client.RebuildIso(ctx, state.ID.ValueString())
+		}
+
+		if err != nil {
+			addIsoError(&resp.Diagnostics, "Error rebuilding ISO", "Could not rebuild the ISO file of ISO Id "+state.ID.ValueString()+".", err)
+			return iso, false
+		}
+	}
+
+	return iso, true
+}
+
+// ImportState adopts an ISO that is still present in the local storage
+func (r *IsoResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	if r.client == nil {
+		resp.Diagnostics.AddError(errClientInit, errClientInitDesc)
+		return
+	}
+
+	// the import only reads the record, expired ISOs are rebuilt by the next refresh or apply
+	iso, err := r.client.LookupIso(ctx, req.ID)
+	if err != nil {
+		if errors.Is(err, ErrIsoNotFound) {
+			resp.Diagnostics.AddError(
+				"ISO not found",
+				"No ISO with Id "+req.ID+" exists in the local storage "+r.client.StorageFolder+". Only ISOs that were created by this provider and are still cached can be imported.",
+			)
+			return
+		}
+
+		addIsoError(&resp.Diagnostics, "Error reading ISO from storage", "Could not read ISO Id "+req.ID+".", err)
+		return
+	}
+
+	exists, err := r.client.IsoFileExists(ctx, iso)
+	if err != nil || !exists {
+		resp.Diagnostics.AddError(
+			"ISO file not found",
+			"The ISO file "+iso.LocalPath+" of ISO Id "+req.ID+" does not exist anymore. Create the resource instead of importing it.",
+		)
+		return
+	}
+
+	var state isoResourceModel
+	setIsoToModel(iso, &state)
+	state.LastUpdated = types.StringValue(iso.CreationTime.Format(time.RFC850))
+	state.ExpiresAt = r.expiresAtToModel(iso)
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+// ModifyPlan computes the fingerprint of the planned ISO and explains in a warning why the ISO will be rebuilt.
+// Evicted ISOs and expired ISOs with the on_apply rebuild policy are planned for an update, which rebuilds them.
+func (r *IsoResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// nothing to do on destroy
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+
+	var plan isoResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if buildInputsKnown(plan) {
+		fingerprint := isoFingerprint(parseIsoFromResourceModel(plan))
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root(fingerprintKey), types.StringValue(fingerprint))...)
+	}
+
+	if r.client == nil {
+		return
+	}
+
+	// nothing more to do on create
+	if req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(validatePlannedDistribution(plan, r.client.CachedDistributions(ctx))...)
+		return
+	}
+
+	var state isoResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if changed := changedBuildAttributes(state, plan); len(changed) > 0 {
+		// ISOs that are not rebuilt keep working when UII drops their operating system
+		resp.Diagnostics.Append(validatePlannedDistribution(plan, r.client.CachedDistributions(ctx))...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+
+		resp.Diagnostics.AddWarning(
+			"ISO will be rebuilt",
+			"The ISO will be replaced and rebuilt by UII, because these attributes changed: "+strings.Join(changed, ", ")+".",
+		)
+		return
+	}
+
+	if !plan.RebuildAfter.Equal(state.RebuildAfter) || !plan.RebuildPolicy.Equal(state.RebuildPolicy) {
+		resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root(expiresAtKey), types.StringUnknown())...)
+	}
+
+	// the refresh doesn't rebuild evicted files, so that a plan never downloads ISOs
+	if stored, err := r.client.LookupIso(ctx, state.ID.ValueString()); err == nil && stored.Evicted {
+		resp.Diagnostics.AddAttributeWarning(
+			path.Root(localPathKey),
+			"ISO file was evicted",
+			"The ISO file "+stored.LocalPath+" was evicted from the local storage and will be rebuilt during apply.",
+		)
+		planRebuild(ctx, resp)
+		return
+	}
+
+	iso := StoredIso{Iso: parseIsoFromResourceModel(plan)}
+	if r.client.rebuildPolicy(iso) != rebuildOnApply || state.ExpiresAt.IsNull() || state.ExpiresAt.IsUnknown() {
+		return
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, state.ExpiresAt.ValueString())
+	if err != nil || expiresAt.After(r.client.now()) {
+		return
+	}
+
+	resp.Diagnostics.AddAttributeWarning(
+		path.Root(expiresAtKey),
+		"ISO expired",
+		"The ISO expired at "+state.ExpiresAt.ValueString()+" and will be rebuilt during apply.",
+	)
+	planRebuild(ctx, resp)
+	// forces the build in Update, evicted files might still be held by other ISOs
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("last_updated"), types.StringUnknown())...)
+}
+
+// planRebuild plans an update that replaces the ISO file with unchanged inputs
+func planRebuild(ctx context.Context, resp *resource.ModifyPlanResponse) {
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root(expiresAtKey), types.StringUnknown())...)
+	// fingerprint and localpath stay the same, so that resources replaced by the fingerprint aren't replaced by routine rebuilds
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root(sha256Key), types.StringUnknown())...)
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root(md5Key), types.StringUnknown())...)
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root(sizeBytesKey), types.Int64Unknown())...)
+}
+
+// changedBuildAttributes lists the attributes affecting the content of the ISO that differ between state and plan
+func changedBuildAttributes(state, plan isoResourceModel) []string {
+	var changed []string
+	values := []struct {
+		key         string
+		state, plan attr.Value
+	}{
+		{isoNameKey, state.Name, plan.Name},
+		{distributionKey, state.Distribution, plan.Distribution},
+		{versionKey, state.Version, plan.Version},
+		{architectureKey, state.Architecture, plan.Architecture},
+		{hostnameKey, state.Hostname, plan.Hostname},
+		{localeKey, state.Locale, plan.Locale},
+		{keyboardKey, state.Keyboard, plan.Keyboard},
+		{enableSSHPasswordAuthenticationKey, state.ShhTroughPasswordEnabled, plan.ShhTroughPasswordEnabled},
+		{timezoneKey, state.Timezone, plan.Timezone},
+	}
+	for _, value := range values {
+		if !value.state.Equal(value.plan) {
+			changed = append(changed, value.key)
+		}
+	}
+
+	if !passwordsEqual(state.Password, plan.Password) {
+		changed = append(changed, passwordKey)
+	}
+
+	if !stringListsEqual(state.SSHKeys, plan.SSHKeys) {
+		changed = append(changed, sshKeysKey)
+	}
+
+	if !stringListsEqual(state.Packages, plan.Packages) {
+		changed = append(changed, packagesKey)
+	}
+
+	if !networksEqual(state.Networks, plan.Networks) {
+		changed = append(changed, networksKey)
+	}
+
+	return changed
+}
+
+// passwordsEqual compares passwords, an imported state only contains the hash of the password
+func passwordsEqual(state, plan types.String) bool {
+	if state.Equal(plan) {
+		return true
+	}
+
+	if state.IsNull() || state.IsUnknown() || plan.IsUnknown() {
+		return false
+	}
+
+	return hashPassword(plan.ValueString()) == state.ValueString()
+}
+
+func stringListsEqual(a, b []types.String) bool {
+	if len(a) != len(b) || (a == nil) != (b == nil) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func networksEqual(a, b []networksModel) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Dhcp.Equal(b[i].Dhcp) ||
+			!a[i].Domain.Equal(b[i].Domain) ||
+			!a[i].Mac.Equal(b[i].Mac) ||
+			!a[i].IP.Equal(b[i].IP) ||
+			!a[i].Gateway.Equal(b[i].Gateway) ||
+			!a[i].NoInternet.Equal(b[i].NoInternet) ||
+			!stringListsEqual(a[i].DNS, b[i].DNS) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validatePlannedDistribution checks the planned operating system of a new or rebuilt ISO against the cached catalog,
+// it is skipped as long as the values are unknown or no catalog is cached
+func validatePlannedDistribution(plan isoResourceModel, distributions []client.OS) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if plan.Distribution.IsUnknown() || plan.Version.IsUnknown() || plan.Architecture.IsUnknown() {
+		return diags
+	}
+
+	err := validateDistribution(plan.Distribution.ValueString(), plan.Version.ValueString(), stringOrDefault(plan.Architecture, ""), distributions)
+	if err != nil {
+		attribute := path.Root(distributionKey)
+		if errors.Is(err, ErrArchitectureRequired) {
+			attribute = path.Root(architectureKey)
+		}
+
+		diags.AddAttributeError(attribute, "Unsupported operating system", err.Error())
+	}
+
+	return diags
+}
+
+// buildInputsKnown checks if all attributes affecting the content of the ISO are known
+func buildInputsKnown(plan isoResourceModel) bool {
+	values := []attr.Value{
+		plan.Distribution, plan.Version, plan.Hostname, plan.Architecture, plan.Locale,
+		plan.Keyboard, plan.Password, plan.ShhTroughPasswordEnabled, plan.Timezone,
+	}
+	values = appendStrings(values, plan.SSHKeys)
+	values = appendStrings(values, plan.Packages)
+	for _, network := range plan.Networks {
+		values = append(values, network.Dhcp, network.Domain, network.Mac, network.IP, network.Gateway, network.NoInternet)
+		values = appendStrings(values, network.DNS)
+	}
+
+	for _, value := range values {
+		if value.IsUnknown() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func appendStrings(values []attr.Value, list []types.String) []attr.Value {
+	for _, item := range list {
+		values = append(values, item)
+	}
+
+	return values
+}
+
+// expiresAtToModel returns the expiry time of the ISO, which is null if the ISO never expires
+func (r *IsoResource) expiresAtToModel(iso StoredIso) types.String {
+	expiresAt, expires := r.client.ExpiryTime(iso)
+	if !expires {
+		return types.StringNull()
+	}
+
+	return types.StringValue(expiresAt.Format(time.RFC3339))
+}
+
 // Update updates the resource and sets the updated Terraform state on success.
 func (r *IsoResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	// Retrieve values from plan
@@ -180,31 +548,36 @@ func (r *IsoResource) Update(ctx context.Context, req resource.UpdateRequest, re
 		return
 	}
 
+	ctx, cancel := plan.Timeouts.updateContext(ctx)
+	defer cancel()
+
 	iso := parseIsoFromResourceModel(plan)
 
+	// an unknown last_updated means that ModifyPlan planned a rebuild of the expired ISO
 	isoID := plan.ID.ValueString()
-	err := r.client.UpdateIso(isoID, iso)
+	err := r.client.UpdateIso(ctx, isoID, iso, plan.LastUpdated.IsUnknown())
 	if err != nil {
-		resp.Diagnostics.AddError(
-			"Error updating Iso",
-			"Could not update ISO :"+err.Error(),
-		)
+		addIsoError(&resp.Diagnostics, "Error updating Iso", "Could not update ISO Id "+isoID+".", err)
 		return
 	}
 
 	// read updated iso to retrieve recomputed values
-	updatedIso, err := r.client.ReadIso(isoID)
+	updatedIso, err := r.client.ReadIso(ctx, isoID)
 	if err != nil {
-		resp.Diagnostics.AddError(
-			"Error reading Iso",
-			"Could not read ISO Id "+isoID+": "+err.Error(),
-		)
+		addIsoError(&resp.Diagnostics, "Error reading Iso", "Could not read ISO Id "+isoID+".", err)
 		return
 	}
 
-	// set computed values
-	plan.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
+	// set computed values, last_updated only changes if the ISO was rebuilt
+	if plan.LastUpdated.IsUnknown() {
+		plan.LastUpdated = types.StringValue(time.Now().Format(time.RFC850))
+	}
 	plan.LocalPath = types.StringValue(updatedIso.LocalPath)
+	plan.ExpiresAt = r.expiresAtToModel(updatedIso)
+	plan.Fingerprint = types.StringValue(updatedIso.Fingerprint)
+	plan.ObjectURL = optionalStringToModel(updatedIso.ObjectURL)
+	plan.PresignedURL = optionalStringToModel(updatedIso.PresignedURL)
+	setChecksumsToModel(updatedIso.Checksums, &plan)
 
 	// Update resource state with updated items and timestamp
 	diags = resp.State.Set(ctx, plan)
@@ -229,16 +602,48 @@ func (r *IsoResource) Delete(ctx context.Context, req resource.DeleteRequest, re
 		return
 	}
 
-	err := r.client.DeleteIso(state.ID.ValueString())
+	ctx, cancel := state.Timeouts.deleteContext(ctx)
+	defer cancel()
+
+	err := r.client.DeleteIso(ctx, state.ID.ValueString())
 	if err != nil {
-		resp.Diagnostics.AddError(
-			"Error deleting ISO",
-			"Could not delete iso, unexpected error: "+err.Error(),
-		)
+		addIsoError(&resp.Diagnostics, "Error deleting ISO", "Could not delete ISO Id "+state.ID.ValueString()+".", err)
 		return
 	}
 }
 
+// addIsoError turns an error of the storage or the UII client into a diagnostic that explains how to resolve it
+func addIsoError(diags *diag.Diagnostics, summary, detail string, err error) {
+	detail = detail + " Error was: " + err.Error()
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		diags.AddError(summary, detail+"\n\nThe operation did not finish in time and partial ISO files were removed. Increase the timeout in the \""+timeoutsKey+"\" block of the resource, or the provider settings \""+maxConcurrentBuildsKey+"\" and \""+requestsPerMinuteKey+"\" if it waited for other builds.")
+	case errors.Is(err, context.Canceled):
+		diags.AddError(summary, detail+"\n\nThe operation was interrupted and partial ISO files were removed.")
+	case errors.Is(err, ErrObjectStorage), errors.Is(err, ErrInvalidS3Config):
+		diags.AddAttributeError(path.Root(objectURLKey), summary, detail+"\n\nThe ISO could not be uploaded. Check the endpoint, bucket and credentials in the \""+s3Key+"\" block of the provider storage settings.")
+	case errors.Is(err, ErrStorageLocked):
+		diags.AddError("Cache locked by another process", detail+"\n\nAnother Terraform run uses the same local storage. Wait for it to finish or increase the provider setting \""+lockTimeoutKey+"\".")
+	case errors.Is(err, ErrStorageCorrupt):
+		diags.AddError(summary, detail+"\n\nThe database "+DataBaseName+" in the local storage can't be read. Move it out of the local storage to start with an empty cache, the ISOs are rebuilt on the next apply.")
+	case errors.Is(err, ErrEncryptionKeyMissing), errors.Is(err, ErrInvalidEncryptionKey):
+		diags.AddError(summary, detail+"\n\nThe records in the local storage are encrypted. Configure their key in \""+storageKey+"."+encryptionKeyKey+"\" or "+EncryptionKeyEnvName+", or in \""+storageKey+"."+previousEncryptionKeyKey+"\" during a key rotation.")
+	case errors.Is(err, ErrStorageVersion):
+		diags.AddError(summary, detail+"\n\nA newer version of the provider used the local storage. Upgrade the provider or configure another \"localstorage\".")
+	case errors.Is(err, ErrStoragePathNotSet), errors.Is(err, ErrStorage), errors.Is(err, ErrBucketNotFound):
+		diags.AddError(summary, detail+"\n\nMake sure the folder configured through \"localstorage\" or "+StorageEnvName+" exists and is writable.")
+	case errors.Is(err, ErrBuildFailed):
+		diags.AddAttributeError(path.Root(distributionKey), summary, detail+"\n\nUII rejected the ISO or could not be reached. Check the distribution, version and networks of the resource and your API token.")
+	case errors.Is(err, ErrDownloadFailed):
+		diags.AddAttributeError(path.Root(localPathKey), summary, detail+"\n\nThe ISO could not be written to the local storage. Check the free disk space and permissions of the storage folder.")
+	case errors.Is(err, ErrIsoNotFound):
+		diags.AddAttributeError(path.Root("id"), summary, detail+"\n\nThe ISO does not exist in the local storage. Remove it from the state to recreate it.")
+	default:
+		diags.AddError(summary, detail)
+	}
+}
+
 func parseIsoFromResourceModel(d isoResourceModel) Iso {
 	name := d.Name.ValueString()
 	distribution := d.Distribution.ValueString()
@@ -251,6 +656,10 @@ func parseIsoFromResourceModel(d isoResourceModel) Iso {
 	shhPasswordAuth := boolOrDefault(d.ShhTroughPasswordEnabled, false)
 	timezone := stringOrDefault(d.Timezone, "")
 	architecture := stringOrDefault(d.Architecture, "")
+	rebuildAfter := stringOrDefault(d.RebuildAfter, "")
+	rebuildPolicy := stringOrDefault(d.RebuildPolicy, "")
+	sshKeys := optionalStringList(d.SSHKeys)
+	packages := optionalStringList(d.Packages)
 
 	networks := parseNetworksFromSchema(d.Networks)
 
@@ -265,10 +674,14 @@ func parseIsoFromResourceModel(d isoResourceModel) Iso {
 			Keyboard:        keyboard,
 			Password:        hashPassword(password),
 			SSHPasswordAuth: shhPasswordAuth,
-			SSHKeys:         nil,
+			SSHKeys:         sshKeys,
 			Timezone:        timezone,
 			Arch:            architecture,
-			Packages:        nil,
+			Packages:        packages,
+		},
+		Rebuild: RebuildOpts{
+			After:  rebuildAfter,
+			Policy: rebuildPolicy,
 		},
 	}
 	return iso
@@ -329,6 +742,67 @@ func stringListWithValidElements(list []types.String) []string {
 	return result
 }
 
+// optionalStringList behaves like stringListWithValidElements, but keeps an unset list as nil
+func optionalStringList(list []types.String) []string {
+	if list == nil {
+		return nil
+	}
+
+	return stringListWithValidElements(list)
+}
+
+// stringListToModel transforms a stored list into the terraform resource model.
+// An empty list is only kept as such if it was empty before, otherwise it stays null.
+func stringListToModel(list []string, previous []types.String) []types.String {
+	if len(list) == 0 {
+		if previous != nil && len(previous) == 0 {
+			return previous
+		}
+		return nil
+	}
+
+	result := []types.String{}
+	for _, item := range list {
+		result = append(result, types.StringValue(item))
+	}
+
+	return result
+}
+
+// stringToModel transforms a stored optional string into the terraform resource model.
+// The store does not distinguish between null and empty strings, so an empty value is only kept if it was empty before.
+func stringToModel(value string, previous types.String) types.String {
+	if value == "" && (previous.IsNull() || previous.IsUnknown() || previous.ValueString() != "") {
+		return types.StringNull()
+	}
+
+	return types.StringValue(value)
+}
+
+// boolToModel transforms a stored optional bool into the terraform resource model.
+// The store does not distinguish between null and false, so false is only kept if it was set before.
+func boolToModel(value bool, previous types.Bool) types.Bool {
+	if !value && (previous.IsNull() || previous.IsUnknown()) {
+		return types.BoolNull()
+	}
+
+	return types.BoolValue(value)
+}
+
+// passwordToModel keeps the configured password as long as it matches the stored hash
+func passwordToModel(hash string, previous types.String) types.String {
+	if hashPassword(stringOrDefault(previous, "")) == hash {
+		return previous
+	}
+
+	if hash == "" {
+		return types.StringNull()
+	}
+
+	// only the hash is stored, which will differ from any configured password and therefore shows up as drift
+	return types.StringValue(hash)
+}
+
 // nolint: unparam // not sure if this is always the case in the future
 func stringOrDefault(data types.String, defaultValue string) string {
 	if data.IsUnknown() {
@@ -355,41 +829,36 @@ func boolOrDefault(data types.Bool, defaultValue bool) bool {
 }
 
 // transformNetworksToModel transforms a Network object as it is stored in the DB into iso into the terraform resource model
-func transformNetworksToModel(networks []Network) []networksModel {
+func transformNetworksToModel(networks []Network, previous []networksModel) []networksModel {
 	var result []networksModel
-	for _, item := range networks {
+	for i, item := range networks {
+		var old networksModel
+		if i < len(previous) {
+			old = previous[i]
+		}
+
 		if item.DHCP {
-			if item.MAC == "" {
-				// this can be the case for single network configurations
-				result = append(result,
-					networksModel{
-						Dhcp:       types.BoolValue(item.DHCP),
-						NoInternet: types.BoolValue(item.NoInternet),
-					},
-				)
-			} else {
-				result = append(result,
-					networksModel{
-						Dhcp:       types.BoolValue(item.DHCP),
-						NoInternet: types.BoolValue(item.NoInternet),
-						Mac:        types.StringValue(item.MAC),
-					},
-				)
-			}
+			// domain, ip, gateway and dns are retrieved through DHCP and therefore never stored
+			result = append(result,
+				networksModel{
+					Dhcp:       types.BoolValue(item.DHCP),
+					NoInternet: types.BoolValue(item.NoInternet),
+					Mac:        stringToModel(item.MAC, old.Mac),
+					Domain:     types.StringNull(),
+					IP:         types.StringNull(),
+					Gateway:    types.StringNull(),
+					DNS:        nil,
+				},
+			)
 		} else {
-			var dnss []types.String
-			for _, dns := range item.DNS {
-				dnss = append(dnss, types.StringValue(dns))
-			}
-
 			result = append(result,
 				networksModel{
 					Dhcp:       types.BoolValue(item.DHCP),
-					Domain:     types.StringValue(item.Domain),
-					Mac:        types.StringValue(item.MAC),
-					IP:         types.StringValue(item.IPNet),
-					Gateway:    types.StringValue(item.Gateway),
-					DNS:        dnss,
+					Domain:     stringToModel(item.Domain, old.Domain),
+					Mac:        stringToModel(item.MAC, old.Mac),
+					IP:         stringToModel(item.IPNet, old.IP),
+					Gateway:    stringToModel(item.Gateway, old.Gateway),
+					DNS:        stringListToModel(item.DNS, old.DNS),
 					NoInternet: types.BoolValue(item.NoInternet),
 				},
 			)
@@ -398,14 +867,49 @@ func transformNetworksToModel(networks []Network) []networksModel {
 	return result
 }
 
-// setIsoToModel writes the data from the stored iso into the terraform resource model
+// setIsoToModel writes the data from the stored iso into the terraform resource model.
+// The current values of the model are used to keep the distinction between null and empty values.
 func setIsoToModel(iso StoredIso, state *isoResourceModel) {
+	state.ID = types.StringValue(iso.ID)
 	state.Name = types.StringValue(iso.Name)
 	state.Distribution = types.StringValue(iso.Distribution)
 	state.Version = types.StringValue(iso.Version)
 	state.Hostname = types.StringValue(iso.HostName)
-	state.Networks = transformNetworksToModel(iso.Networks)
+	state.Networks = transformNetworksToModel(iso.Networks, state.Networks)
+	state.Locale = stringToModel(iso.Optionals.Locale, state.Locale)
+	state.Keyboard = stringToModel(iso.Optionals.Keyboard, state.Keyboard)
+	state.Password = passwordToModel(iso.Optionals.Password, state.Password)
+	state.ShhTroughPasswordEnabled = boolToModel(iso.Optionals.SSHPasswordAuth, state.ShhTroughPasswordEnabled)
+	state.SSHKeys = stringListToModel(iso.Optionals.SSHKeys, state.SSHKeys)
+	state.Timezone = stringToModel(iso.Optionals.Timezone, state.Timezone)
+	state.Architecture = stringToModel(iso.Optionals.Arch, state.Architecture)
+	state.Packages = stringListToModel(iso.Optionals.Packages, state.Packages)
+	state.RebuildAfter = stringToModel(iso.Rebuild.After, state.RebuildAfter)
+	state.RebuildPolicy = stringToModel(iso.Rebuild.Policy, state.RebuildPolicy)
 	state.LocalPath = types.StringValue(iso.LocalPath)
+	state.Fingerprint = types.StringValue(iso.Fingerprint)
+	state.ObjectURL = optionalStringToModel(iso.ObjectURL)
+	state.PresignedURL = optionalStringToModel(iso.PresignedURL)
+	setChecksumsToModel(iso.Checksums, state)
+}
+
+// setChecksumsToModel sets the checksums of the ISO file, which are null for ISOs that were not hashed yet
+func setChecksumsToModel(checksums IsoChecksums, state *isoResourceModel) {
+	state.SHA256 = optionalStringToModel(checksums.SHA256)
+	state.MD5 = optionalStringToModel(checksums.MD5)
+	state.SizeBytes = types.Int64Null()
+	if checksums.SHA256 != "" {
+		state.SizeBytes = types.Int64Value(checksums.SizeBytes)
+	}
+}
+
+// optionalStringToModel returns null for values that are only set in some configurations
+func optionalStringToModel(value string) types.String {
+	if value == "" {
+		return types.StringNull()
+	}
+
+	return types.StringValue(value)
 }
 
 func hashPassword(password string) string {
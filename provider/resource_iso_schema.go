@@ -5,6 +5,11 @@ import (
 
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/boolplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/listplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 )
 
@@ -36,6 +41,17 @@ const enableSSHPasswordAuthenticationKey = "enable_ssh_authentication_through_pa
 const sshKeysKey = "ssh_keys"
 const localeKey = "locale"
 
+const onMissingFileKey = "on_missing_file"
+const rebuildAfterKey = "rebuild_after"
+const rebuildPolicyKey = "rebuild_policy"
+const expiresAtKey = "expires_at"
+const fingerprintKey = "fingerprint"
+const objectURLKey = "object_url"
+const presignedURLKey = "presigned_url"
+const sha256Key = "sha256"
+const md5Key = "md5"
+const sizeBytesKey = "size_bytes"
+
 // orderResourceModel maps the resource schema data.
 type isoResourceModel struct {
 	ID                       types.String    `tfsdk:"id"`
@@ -54,6 +70,17 @@ type isoResourceModel struct {
 	Timezone                 types.String    `tfsdk:"timezone"`
 	Packages                 []types.String  `tfsdk:"packages"`
 	Networks                 []networksModel `tfsdk:"networks"`
+	OnMissingFile            types.String    `tfsdk:"on_missing_file"`
+	RebuildAfter             types.String    `tfsdk:"rebuild_after"`
+	RebuildPolicy            types.String    `tfsdk:"rebuild_policy"`
+	ExpiresAt                types.String    `tfsdk:"expires_at"`
+	Fingerprint              types.String    `tfsdk:"fingerprint"`
+	ObjectURL                types.String    `tfsdk:"object_url"`
+	PresignedURL             types.String    `tfsdk:"presigned_url"`
+	SHA256                   types.String    `tfsdk:"sha256"`
+	MD5                      types.String    `tfsdk:"md5"`
+	SizeBytes                types.Int64     `tfsdk:"size_bytes"`
+	Timeouts                 *timeoutsModel  `tfsdk:"timeouts"`
 }
 
 // orderItemCoffeeModel maps coffee order item data.
@@ -74,27 +101,45 @@ func (r *IsoResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *
 		Attributes: map[string]schema.Attribute{
 			"id": schema.StringAttribute{
 				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
 			},
 			"last_updated": schema.StringAttribute{
 				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
 			},
 
 			isoNameKey: schema.StringAttribute{
 				Required: true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			distributionKey: schema.StringAttribute{
 				Required:            true,
 				Description:         "The distribution, for example \"debian\"",
 				MarkdownDescription: "The distribution, for example `debian`",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			versionKey: schema.StringAttribute{
 				Description:         "The version of the distribution, for example \"11\"",
 				MarkdownDescription: "The version of the distribution, for example `11`",
 				Required:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			hostnameKey: schema.StringAttribute{
 				Required:    true,
 				Description: "The host name to be configured during the installation",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			networksKey: schema.ListNestedAttribute{
 				Required:    true,
@@ -133,10 +178,57 @@ func (r *IsoResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *
 						},
 					},
 				},
+				PlanModifiers: []planmodifier.List{
+					listplanmodifier.RequiresReplace(),
+				},
 			},
 			localPathKey: schema.StringAttribute{
 				Computed:    true,
-				Description: "The path where the ISO is temporary cached after its creation.",
+				Description: "The path where the ISO is temporary cached after its creation. ISOs with identical inputs share one cached file.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			fingerprintKey: schema.StringAttribute{
+				Computed:    true,
+				Description: "A SHA-256 hash of all inputs that affect the content of the ISO. It only changes if one of the inputs changes, not when an expired ISO is rebuilt, which makes it usable as a replacement trigger for virtual machines.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			sha256Key: schema.StringAttribute{
+				Computed:    true,
+				Description: "The SHA-256 checksum of the ISO file, computed after each build. The ISO file is verified against it during refresh.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			md5Key: schema.StringAttribute{
+				Computed:    true,
+				Description: "The MD5 checksum of the ISO file, for tools that don't support SHA-256.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			sizeBytesKey: schema.Int64Attribute{
+				Computed:    true,
+				Description: "The size of the ISO file in bytes.",
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.UseStateForUnknown(),
+				},
+			},
+
+			objectURLKey: schema.StringAttribute{
+				Computed:    true,
+				Description: "The URL of the uploaded ISO, if an S3 storage is configured in the provider.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			presignedURLKey: schema.StringAttribute{
+				Computed:    true,
+				Sensitive:   true,
+				Description: "A presigned URL granting temporary read access to the uploaded ISO, if an S3 storage is configured in the provider. It is renewed during refresh.",
 			},
 
 			// Optional parameters
@@ -144,41 +236,99 @@ func (r *IsoResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *
 				Optional:            true,
 				Description:         "The locale used for the OS. For example \"en-en\". Defaults to English.",
 				MarkdownDescription: "The locale used for the OS. For example `en-en`. Defaults to English.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			keyboardKey: schema.StringAttribute{
 				Optional:            true,
 				Description:         "The keyboard layout used for the OS. For example \"en-en\". Defaults to English.",
 				MarkdownDescription: "The keyboard layout used for the OS. For example `en-en`. Defaults to English.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			passwordKey: schema.StringAttribute{
 				Optional:            true,
 				Description:         "A password to be set the \"root\" user. The default password if this parameter is not set is \"virtomize\".",
 				MarkdownDescription: "A password to be set the `root` user. The default password if this parameter is not set is `virtomize`.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplaceIf(
+						func(_ context.Context, req planmodifier.StringRequest, resp *stringplanmodifier.RequiresReplaceIfFuncResponse) {
+							resp.RequiresReplace = !passwordsEqual(req.StateValue, req.PlanValue)
+						},
+						"Changing the password requires a rebuild of the ISO.",
+						"Changing the password requires a rebuild of the ISO.",
+					),
+				},
 			},
 			enableSSHPasswordAuthenticationKey: schema.BoolAttribute{
 				Optional:    true,
 				Description: "If true, login into the OS through SSH will be enabled.",
+				PlanModifiers: []planmodifier.Bool{
+					boolplanmodifier.RequiresReplace(),
+				},
 			},
 			sshKeysKey: schema.ListAttribute{
 				ElementType: types.StringType,
 				Optional:    true,
 				Description: "A list of SSH keys to be installed for use with the SSH login.",
+				PlanModifiers: []planmodifier.List{
+					listplanmodifier.RequiresReplace(),
+				},
 			},
 
 			timezoneKey: schema.StringAttribute{
 				Optional:    true,
 				Description: "The timezone to be used by the OS.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			architectureKey: schema.StringAttribute{
 				Optional:            true,
 				Description:         "The architecture variant of the OS that should be installed. \"32\" or \"64\". Defaults to 64.",
 				MarkdownDescription: "The architecture variant of the OS that should be installed. `32` or `64`. Defaults to `64`.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
 			},
 			packagesKey: schema.ListAttribute{
 				ElementType: types.StringType,
 				Optional:    true,
 				Description: "A list of additional packages that should be installed in addition to the necessary ones.",
+				PlanModifiers: []planmodifier.List{
+					listplanmodifier.RequiresReplace(),
+				},
 			},
+
+			// Behaviour of the provider
+			onMissingFileKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "What to do if the cached ISO file was removed from disk: \"rebuild\" recreates it during refresh, \"recreate\" removes the resource from the state so that the next apply creates it again. Overrides the provider setting.",
+				MarkdownDescription: "What to do if the cached ISO file was removed from disk: `rebuild` recreates it during refresh, `recreate` removes the resource from the state so that the next apply creates it again. Overrides the provider setting.",
+			},
+			rebuildAfterKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "The duration after which the ISO expires and is rebuilt, for example \"24h\". Overrides the provider setting.",
+				MarkdownDescription: "The duration after which the ISO expires and is rebuilt, for example `24h`. Overrides the provider setting.",
+			},
+			rebuildPolicyKey: schema.StringAttribute{
+				Optional:            true,
+				Description:         "When an expired ISO is rebuilt: \"on_read\" during refresh, \"on_apply\" by planning an update, \"never\" lets the ISO never expire. Overrides the provider setting.",
+				MarkdownDescription: "When an expired ISO is rebuilt: `on_read` during refresh, `on_apply` by planning an update, `never` lets the ISO never expire. Overrides the provider setting.",
+			},
+			expiresAtKey: schema.StringAttribute{
+				Computed:    true,
+				Description: "The time in RFC 3339 format after which the ISO expires. Not set if the ISO never expires.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+		},
+
+		Blocks: map[string]schema.Block{
+			timeoutsKey: timeoutsBlock(),
 		},
 	}
 }
@@ -0,0 +1,411 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func fullIsoModel() isoResourceModel {
+	return isoResourceModel{
+		ID:                       types.StringValue("debian_iso"),
+		Name:                     types.StringValue("debian_iso"),
+		Distribution:             types.StringValue("debian"),
+		Version:                  types.StringValue("11"),
+		Architecture:             types.StringValue("64"),
+		Hostname:                 types.StringValue("examplehost"),
+		Locale:                   types.StringValue("en-GB"),
+		Keyboard:                 types.StringValue("de-DE"),
+		Password:                 types.StringValue("secret"),
+		ShhTroughPasswordEnabled: types.BoolValue(true),
+		SSHKeys:                  []types.String{types.StringValue("ssh-ed25519 AAAA")},
+		Timezone:                 types.StringValue("Europe/Berlin"),
+		Packages:                 []types.String{types.StringValue("vim")},
+		LocalPath:                types.StringValue("/tmp/debian_iso.iso"),
+		Networks: []networksModel{
+			{
+				Dhcp:       types.BoolValue(true),
+				Mac:        types.StringValue("ca:8c:65:0d:e7:57"),
+				NoInternet: types.BoolValue(false),
+			},
+			{
+				Dhcp:       types.BoolValue(false),
+				Domain:     types.StringValue("example.com"),
+				Mac:        types.StringValue("ca:8c:65:0d:e7:58"),
+				IP:         types.StringValue("10.0.0.2/24"),
+				Gateway:    types.StringValue("10.0.0.1"),
+				DNS:        []types.String{types.StringValue("1.1.1.1")},
+				NoInternet: types.BoolValue(true),
+			},
+		},
+	}
+}
+
+func TestSetIsoToModelRestoresAllAttributes(t *testing.T) {
+	config := fullIsoModel()
+	stored := StoredIso{ID: "debian_iso", Iso: parseIsoFromResourceModel(config), LocalPath: "/tmp/debian_iso.iso", Fingerprint: "abc"}
+
+	// reading into an empty model, as done on import, restores everything but the clear text password
+	var state isoResourceModel
+	setIsoToModel(stored, &state)
+
+	expected := fullIsoModel()
+	expected.Password = types.StringValue(hashPassword("secret"))
+	expected.Fingerprint = types.StringValue("abc")
+	assert.Equal(t, expected, state)
+
+	// reading into the configured model leaves it untouched
+	state = fullIsoModel()
+	setIsoToModel(stored, &state)
+	expected = fullIsoModel()
+	expected.Fingerprint = types.StringValue("abc")
+	assert.Equal(t, expected, state)
+}
+
+func TestSetIsoToModelDetectsDrift(t *testing.T) {
+	config := fullIsoModel()
+	iso := parseIsoFromResourceModel(config)
+	iso.Optionals.Locale = "fr-FR"
+	iso.Optionals.Timezone = ""
+	iso.Optionals.SSHPasswordAuth = false
+	iso.Optionals.Packages = nil
+	iso.Optionals.Password = hashPassword("other")
+	iso.Networks[1].Gateway = "10.0.0.254"
+
+	state := fullIsoModel()
+	setIsoToModel(StoredIso{ID: "debian_iso", Iso: iso}, &state)
+
+	assert.Equal(t, types.StringValue("fr-FR"), state.Locale)
+	assert.Equal(t, types.StringNull(), state.Timezone)
+	assert.Equal(t, types.BoolValue(false), state.ShhTroughPasswordEnabled)
+	assert.Nil(t, state.Packages)
+	assert.Equal(t, types.StringValue(hashPassword("other")), state.Password)
+	assert.Equal(t, types.StringValue("10.0.0.254"), state.Networks[1].Gateway)
+}
+
+func TestSetIsoToModelKeepsNullAndEmptyValues(t *testing.T) {
+	config := isoResourceModel{
+		Name:                     types.StringValue("debian_iso"),
+		Distribution:             types.StringValue("debian"),
+		Version:                  types.StringValue("11"),
+		Hostname:                 types.StringValue("examplehost"),
+		Locale:                   types.StringValue(""),
+		Keyboard:                 types.StringNull(),
+		Password:                 types.StringNull(),
+		ShhTroughPasswordEnabled: types.BoolValue(false),
+		Timezone:                 types.StringNull(),
+		Architecture:             types.StringNull(),
+		SSHKeys:                  []types.String{},
+		Packages:                 nil,
+		Networks: []networksModel{{
+			Dhcp:       types.BoolValue(false),
+			Domain:     types.StringNull(),
+			Mac:        types.StringValue(""),
+			IP:         types.StringValue("10.0.0.2/24"),
+			Gateway:    types.StringValue("10.0.0.1"),
+			DNS:        []types.String{},
+			NoInternet: types.BoolValue(false),
+		}},
+	}
+	stored := StoredIso{ID: "debian_iso", Iso: parseIsoFromResourceModel(config)}
+
+	state := config
+	state.Networks = append([]networksModel{}, config.Networks...)
+	setIsoToModel(stored, &state)
+
+	config.ID = types.StringValue("debian_iso")
+	config.LocalPath = types.StringValue("")
+	config.Fingerprint = types.StringValue("")
+	assert.Equal(t, config, state)
+}
+
+func TestChangedBuildAttributes(t *testing.T) {
+	state := fullIsoModel()
+
+	assert.Empty(t, changedBuildAttributes(state, fullIsoModel()))
+
+	plan := fullIsoModel()
+	plan.RebuildAfter = types.StringValue("1h")
+	plan.LastUpdated = types.StringUnknown()
+	assert.Empty(t, changedBuildAttributes(state, plan))
+
+	plan = fullIsoModel()
+	plan.Distribution = types.StringValue("ubuntu")
+	plan.Hostname = types.StringUnknown()
+	plan.Packages = nil
+	plan.Networks[1].DNS = []types.String{types.StringValue("8.8.8.8")}
+	assert.Equal(t, []string{distributionKey, hostnameKey, packagesKey, networksKey}, changedBuildAttributes(state, plan))
+}
+
+func importTestIso(t *testing.T, c *clientWithStorage, id string) (isoResourceModel, diag.Diagnostics) {
+	r := &IsoResource{client: c}
+	schemaResp := &resource.SchemaResponse{}
+	r.Schema(context.Background(), resource.SchemaRequest{}, schemaResp)
+	assert.False(t, schemaResp.Diagnostics.HasError())
+
+	resp := &resource.ImportStateResponse{
+		State: tfsdk.State{
+			Schema: schemaResp.Schema,
+			Raw:    tftypes.NewValue(schemaResp.Schema.Type().TerraformType(context.Background()), nil),
+		},
+	}
+	r.ImportState(context.Background(), resource.ImportStateRequest{ID: id}, resp)
+
+	var state isoResourceModel
+	if !resp.Diagnostics.HasError() {
+		resp.Diagnostics.Append(resp.State.Get(context.Background(), &state)...)
+	}
+
+	return state, resp.Diagnostics
+}
+
+func TestImportState(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	iso := parseIsoFromResourceModel(fullIsoModel())
+	stored, err := c.CreateIso(context.Background(), iso)
+	assert.NoError(t, err)
+
+	state, diags := importTestIso(t, c, "debian_iso")
+	assert.False(t, diags.HasError(), diags)
+
+	expected := fullIsoModel()
+	expected.Password = types.StringValue(hashPassword("secret"))
+	expected.LocalPath = types.StringValue(stored.LocalPath)
+	expected.Fingerprint = types.StringValue(stored.Fingerprint)
+	expected.SHA256 = types.StringValue(stored.Checksums.SHA256)
+	expected.MD5 = types.StringValue(stored.Checksums.MD5)
+	expected.SizeBytes = types.Int64Value(int64(len("iso for examplehost")))
+	expected.LastUpdated = types.StringValue(stored.CreationTime.Format(time.RFC850))
+	expected.ExpiresAt = types.StringValue(stored.CreationTime.Add(defaultRebuildAfter).Format(time.RFC3339))
+	assert.Equal(t, expected, state)
+
+	// the imported password hash does not cause a replacement
+	assert.Empty(t, changedBuildAttributes(state, fullIsoModel()))
+
+	_, diags = importTestIso(t, c, "unknown_iso")
+	assert.True(t, diags.HasError())
+	assert.Equal(t, "ISO not found", diags[0].Summary())
+
+	assert.NoError(t, os.Remove(stored.LocalPath))
+	_, diags = importTestIso(t, c, "debian_iso")
+	assert.True(t, diags.HasError())
+	assert.Equal(t, "ISO file not found", diags[0].Summary())
+}
+
+func TestImportDoesNotBuild(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.RebuildPolicy = rebuildOnRead
+	stored, err := c.CreateIso(context.Background(), parseIsoFromResourceModel(fullIsoModel()))
+	assert.NoError(t, err)
+
+	// an expired ISO is imported as it is
+	c.TimeProvider = &fixedTimeProvider{now: stored.CreationTime.Add(defaultRebuildAfter + time.Hour)}
+	state, diags := importTestIso(t, c, "debian_iso")
+	assert.False(t, diags.HasError(), diags)
+	assert.Equal(t, stored.Checksums.SHA256, state.SHA256.ValueString())
+	assert.Len(t, fake.builds, 1)
+
+	// an evicted ISO is not rebuilt
+	store, err := c.openStore()
+	assert.NoError(t, err)
+	stored.Evicted = true
+	assert.NoError(t, store.WriteIso(context.Background(), stored))
+	assert.NoError(t, os.Remove(stored.LocalPath))
+
+	_, diags = importTestIso(t, c, "debian_iso")
+	assert.True(t, diags.HasError())
+	assert.Equal(t, "ISO file not found", diags[0].Summary())
+	assert.Len(t, fake.builds, 1)
+}
+
+func TestAddIsoError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		path path.Path
+	}{
+		{err: ErrStoragePathNotSet},
+		{err: fmt.Errorf("%w: timeout", ErrStorage)},
+		{err: fmt.Errorf("%w: record of iso debian_iso", ErrStorageCorrupt)},
+		{err: fmt.Errorf("%w: schema version 9", ErrStorageVersion)},
+		{err: fmt.Errorf("%w: record of iso debian_iso uses key 0a1b2c3d", ErrEncryptionKeyMissing)},
+		{err: fmt.Errorf("%w: bad request", ErrBuildFailed), path: path.Root(distributionKey)},
+		{err: fmt.Errorf("%w: disk full", ErrDownloadFailed), path: path.Root(localPathKey)},
+		{err: fmt.Errorf("%w: debian_iso", ErrIsoNotFound), path: path.Root("id")},
+		{err: fmt.Errorf("%w: PUT returned 403", ErrObjectStorage), path: path.Root(objectURLKey)},
+		{err: errors.New("unexpected")},
+	} {
+		var diags diag.Diagnostics
+		addIsoError(&diags, "Error creating iso", "Could not create ISO debian_iso.", tc.err)
+		assert.Len(t, diags, 1)
+		assert.Equal(t, "Error creating iso", diags[0].Summary())
+		assert.Contains(t, diags[0].Detail(), tc.err.Error())
+
+		withPath, ok := diags[0].(diag.DiagnosticWithPath)
+		if len(tc.path.Steps()) == 0 {
+			assert.False(t, ok)
+		} else {
+			assert.True(t, ok)
+			assert.Equal(t, tc.path, withPath.Path())
+		}
+	}
+}
+
+func TestAddIsoErrorForLockedStorage(t *testing.T) {
+	var diags diag.Diagnostics
+	addIsoError(&diags, "Error reading ISO from storage", "Could not read ISO Id debian_iso.", fmt.Errorf("%w: /tmp/uii", ErrStorageLocked))
+	assert.Len(t, diags, 1)
+	assert.Equal(t, "Cache locked by another process", diags[0].Summary())
+	assert.Contains(t, diags[0].Detail(), lockTimeoutKey)
+}
+
+func readTestIso(t *testing.T, c *clientWithStorage, state isoResourceModel) (*resource.ReadResponse, isoResourceModel) {
+	r := &IsoResource{client: c}
+	schemaResp := &resource.SchemaResponse{}
+	r.Schema(context.Background(), resource.SchemaRequest{}, schemaResp)
+	assert.False(t, schemaResp.Diagnostics.HasError())
+
+	req := resource.ReadRequest{State: tfsdk.State{Schema: schemaResp.Schema}}
+	assert.False(t, req.State.Set(context.Background(), &state).HasError())
+	resp := &resource.ReadResponse{State: req.State}
+	r.Read(context.Background(), req, resp)
+
+	var refreshed isoResourceModel
+	if !resp.State.Raw.IsNull() {
+		resp.Diagnostics.Append(resp.State.Get(context.Background(), &refreshed)...)
+	}
+
+	return resp, refreshed
+}
+
+func TestReadReportsCorruptIsoFile(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.MissingFilePolicy = missingFileRecreate
+	_, err := c.CreateIso(context.Background(), parseIsoFromResourceModel(fullIsoModel()))
+	assert.NoError(t, err)
+
+	state, diags := importTestIso(t, c, "debian_iso")
+	assert.False(t, diags.HasError(), diags)
+
+	// an intact file keeps the resource
+	resp, refreshed := readTestIso(t, c, state)
+	assert.False(t, resp.Diagnostics.HasError(), resp.Diagnostics)
+	assert.Equal(t, state.SHA256, refreshed.SHA256)
+
+	assert.NoError(t, os.WriteFile(state.LocalPath.ValueString(), []byte("tampered"), 0600))
+
+	// the recreate policy removes the resource from the state, so that the next apply creates it again
+	resp, _ = readTestIso(t, c, state)
+	assert.False(t, resp.Diagnostics.HasError(), resp.Diagnostics)
+	assert.True(t, resp.State.Raw.IsNull())
+	assert.Equal(t, "ISO file is corrupt", resp.Diagnostics.Warnings()[0].Summary())
+
+	// the rebuild policy builds the ISO again, although the corrupt file exists
+	state.OnMissingFile = types.StringValue(missingFileRebuild)
+	resp, refreshed = readTestIso(t, c, state)
+	assert.False(t, resp.Diagnostics.HasError(), resp.Diagnostics)
+	assert.Len(t, fake.builds, 2)
+	assert.Equal(t, state.SHA256, refreshed.SHA256)
+	assert.Equal(t, []byte("iso for examplehost"), readFile(t, refreshed.LocalPath.ValueString()))
+}
+
+// planTestIso runs ModifyPlan, a nil state plans the creation of the ISO
+func planTestIso(t *testing.T, c *clientWithStorage, state *isoResourceModel, plan isoResourceModel) (*resource.ModifyPlanResponse, isoResourceModel) {
+	r := &IsoResource{client: c}
+	schemaResp := &resource.SchemaResponse{}
+	r.Schema(context.Background(), resource.SchemaRequest{}, schemaResp)
+	assert.False(t, schemaResp.Diagnostics.HasError())
+
+	req := resource.ModifyPlanRequest{
+		State: tfsdk.State{
+			Schema: schemaResp.Schema,
+			Raw:    tftypes.NewValue(schemaResp.Schema.Type().TerraformType(context.Background()), nil),
+		},
+		Plan: tfsdk.Plan{Schema: schemaResp.Schema},
+	}
+	if state != nil {
+		assert.False(t, req.State.Set(context.Background(), state).HasError())
+	}
+	assert.False(t, req.Plan.Set(context.Background(), &plan).HasError())
+	resp := &resource.ModifyPlanResponse{Plan: req.Plan}
+	r.ModifyPlan(context.Background(), req, resp)
+
+	var planned isoResourceModel
+	resp.Diagnostics.Append(resp.Plan.Get(context.Background(), &planned)...)
+	return resp, planned
+}
+
+func TestExpiredIsoKeepsFingerprint(t *testing.T) {
+	c, _ := newTestClient(t)
+	_, err := c.CreateIso(context.Background(), parseIsoFromResourceModel(fullIsoModel()))
+	assert.NoError(t, err)
+
+	state, diags := importTestIso(t, c, "debian_iso")
+	assert.False(t, diags.HasError(), diags)
+	state.ExpiresAt = types.StringValue(c.TimeProvider.Now().Add(-time.Hour).Format(time.RFC3339))
+
+	plan := state
+	plan.Password = types.StringValue("secret")
+	resp, planned := planTestIso(t, c, &state, plan)
+	assert.False(t, resp.Diagnostics.HasError(), resp.Diagnostics)
+	assert.Equal(t, "ISO expired", resp.Diagnostics.Warnings()[0].Summary())
+
+	// the rebuild changes the content of the ISO, but not what replace_triggered_by depends on
+	assert.Equal(t, state.Fingerprint, planned.Fingerprint)
+	assert.Equal(t, state.LocalPath, planned.LocalPath)
+	assert.True(t, planned.ExpiresAt.IsUnknown())
+	assert.True(t, planned.LastUpdated.IsUnknown())
+	assert.True(t, planned.SHA256.IsUnknown())
+	assert.True(t, planned.MD5.IsUnknown())
+	assert.True(t, planned.SizeBytes.IsUnknown())
+}
+
+func TestEvictedIsoIsRebuiltDuringApply(t *testing.T) {
+	c, fake := newTestClient(t)
+	_, err := c.CreateIso(context.Background(), parseIsoFromResourceModel(fullIsoModel()))
+	assert.NoError(t, err)
+
+	state, diags := importTestIso(t, c, "debian_iso")
+	assert.False(t, diags.HasError(), diags)
+
+	// the next run evicts the file before the resource is refreshed
+	c, fake = newTestClientOnFolder(t, c, c.TimeProvider.Now().Add(2*time.Hour))
+	c.MaxCacheAge = time.Hour
+	evicted, err := c.EvictIsoFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"debian_iso"}, evicted)
+
+	// the refresh keeps the resource without downloading the ISO
+	resp, refreshed := readTestIso(t, c, state)
+	assert.False(t, resp.Diagnostics.HasError(), resp.Diagnostics)
+	assert.Empty(t, resp.Diagnostics.Warnings())
+	assert.Equal(t, state.LocalPath, refreshed.LocalPath)
+	assert.Empty(t, fake.builds)
+
+	// the plan updates the resource, which rebuilds the file
+	plan := refreshed
+	plan.Password = types.StringValue("secret")
+	planResp, planned := planTestIso(t, c, &refreshed, plan)
+	assert.False(t, planResp.Diagnostics.HasError(), planResp.Diagnostics)
+	assert.Equal(t, "ISO file was evicted", planResp.Diagnostics.Warnings()[0].Summary())
+	assert.Equal(t, state.Fingerprint, planned.Fingerprint)
+	assert.Equal(t, state.LocalPath, planned.LocalPath)
+	assert.True(t, planned.SHA256.IsUnknown())
+	assert.False(t, planned.LastUpdated.IsUnknown())
+	assert.Empty(t, fake.builds)
+
+	assert.NoError(t, c.UpdateIso(context.Background(), "debian_iso", parseIsoFromResourceModel(planned), planned.LastUpdated.IsUnknown()))
+	assert.Len(t, fake.builds, 1)
+	assert.FileExists(t, state.LocalPath.ValueString())
+}
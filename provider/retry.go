@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	retryMaxAttemptsKey = "retry_max_attempts"
+	retryMaxBackoffKey  = "retry_max_backoff"
+
+	defaultRetryMaxAttempts = 4
+	defaultRetryMaxBackoff  = 30 * time.Second
+	// initialRetryBackoff is the delay before the first retry, it doubles with every attempt
+	initialRetryBackoff = time.Second
+)
+
+// retryableStatusCodes are the responses of the UII API that might succeed when the request is sent again
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooEarly:            true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// retryingUiiClient is an IUiiClient that sends failed requests again, with an exponential backoff and jitter between
+// the attempts. Only transient errors are retried, like 5xx responses, timeouts and reset connections.
+type retryingUiiClient struct {
+	client      IUiiClient
+	maxAttempts int
+	maxBackoff  time.Duration
+
+	// sleep and jitter are replaced in tests
+	sleep  func(context.Context, time.Duration) error
+	jitter func(time.Duration) time.Duration
+}
+
+// newRetryingUiiClient wraps the client, values below one fall back to the defaults
+func newRetryingUiiClient(c IUiiClient, maxAttempts int, maxBackoff time.Duration) *retryingUiiClient {
+	if maxAttempts < 1 {
+		maxAttempts = defaultRetryMaxAttempts
+	}
+
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	return &retryingUiiClient{
+		client:      c,
+		maxAttempts: maxAttempts,
+		maxBackoff:  maxBackoff,
+		sleep:       sleepContext,
+		jitter:      fullJitter,
+	}
+}
+
+// sleepContext waits for the delay, or returns the error of the context if it is done first
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fullJitter returns a random delay between half and all of the backoff, so that parallel runs don't retry in lockstep
+func fullJitter(backoff time.Duration) time.Duration {
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1)) //nolint: gosec // no security relevance
+}
+
+// Build builds the ISO, retrying transient errors
+func (c *retryingUiiClient) Build(ctx context.Context, filePath string, args client.BuildArgs, opts client.BuildOpts) error {
+	return c.retry(ctx, "build "+args.Hostname, func() error {
+		return c.client.Build(ctx, filePath, args, opts)
+	})
+}
+
+// OperatingSystems lists the operating systems, retrying transient errors
+func (c *retryingUiiClient) OperatingSystems(ctx context.Context) ([]client.OS, error) {
+	var operatingSystems []client.OS
+	err := c.retry(ctx, "list operating systems", func() error {
+		var err error
+		operatingSystems, err = c.client.OperatingSystems(ctx)
+		return err
+	})
+
+	return operatingSystems, err
+}
+
+// retry calls the request until it succeeds, fails permanently, the attempts are used up or the context is done. The
+// attempts are logged with the logger of the context.
+func (c *retryingUiiClient) retry(ctx context.Context, request string, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		fields := map[string]interface{}{"request": request, "attempt": attempt, "max_attempts": c.maxAttempts}
+		if err == nil {
+			if attempt > 1 {
+				tflog.Info(ctx, "UII request succeeded after retrying", fields)
+			}
+			return nil
+		}
+
+		fields["error"] = err.Error()
+		if ctx.Err() != nil {
+			tflog.Debug(ctx, "UII request canceled", fields)
+			return err
+		}
+
+		if !isRetryableError(err) {
+			tflog.Debug(ctx, "UII request failed permanently", fields)
+			return err
+		}
+
+		if attempt >= c.maxAttempts {
+			tflog.Warn(ctx, "UII request failed, no attempts left", fields)
+			return err
+		}
+
+		delay, ok := c.backoff(attempt, err)
+		if !ok {
+			tflog.Warn(ctx, "UII request failed, the server asked to retry later than the maximum backoff", fields)
+			return err
+		}
+
+		fields["delay"] = delay.String()
+		tflog.Warn(ctx, "UII request failed, retrying", fields)
+		if c.sleep(ctx, delay) != nil {
+			// the error of the last attempt explains more than the canceled context
+			return err
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt. A Retry-After of the server is honored, unless it is longer than
+// the maximum backoff, then it returns false.
+func (c *retryingUiiClient) backoff(attempt int, err error) (time.Duration, bool) {
+	var statusErr *uiiStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter, statusErr.RetryAfter <= c.maxBackoff
+	}
+
+	backoff := c.maxBackoff
+	if attempt < 32 && initialRetryBackoff<<(attempt-1) < c.maxBackoff {
+		backoff = initialRetryBackoff << (attempt - 1)
+	}
+
+	return c.jitter(backoff), true
+}
+
+// isRetryableError checks if the error is transient. Certificate errors, rejected requests and errors writing the local
+// file are permanent.
+func isRetryableError(err error) bool {
+	var statusErr *uiiStatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatusCodes[statusErr.StatusCode]
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCertificate x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var recordHeader tls.RecordHeaderError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCertificate) || errors.As(err, &hostname) || errors.As(err, &recordHeader) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		// alerts of the TLS handshake, like a rejected client certificate
+		return opErr.Op != "remote error"
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
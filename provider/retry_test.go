@@ -0,0 +1,251 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyUiiClient is an IUiiClient that fails a number of times before it succeeds like fakeUiiClient
+type flakyUiiClient struct {
+	fakeUiiClient
+	failures int
+	err      error
+	attempts int
+}
+
+func (c *flakyUiiClient) Build(ctx context.Context, filePath string, args client.BuildArgs, opts client.BuildOpts) error {
+	c.attempts++
+	if c.attempts <= c.failures {
+		return c.err
+	}
+
+	return c.fakeUiiClient.Build(ctx, filePath, args, opts)
+}
+
+func (c *flakyUiiClient) OperatingSystems(ctx context.Context) ([]client.OS, error) {
+	c.attempts++
+	if c.attempts <= c.failures {
+		return nil, c.err
+	}
+
+	return c.fakeUiiClient.OperatingSystems(ctx)
+}
+
+// newTestRetryingClient wraps the flaky client without sleeping and jitter, the delays are recorded instead
+func newTestRetryingClient(flaky *flakyUiiClient, maxAttempts int, maxBackoff time.Duration) (*retryingUiiClient, *[]time.Duration) {
+	var delays []time.Duration
+	c := newRetryingUiiClient(flaky, maxAttempts, maxBackoff)
+	c.sleep = func(_ context.Context, delay time.Duration) error {
+		delays = append(delays, delay)
+		return nil
+	}
+	c.jitter = func(backoff time.Duration) time.Duration { return backoff }
+	return c, &delays
+}
+
+func TestRetryBuildSucceedsAfterTransientErrors(t *testing.T) {
+	var logs bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &logs)
+
+	flaky := &flakyUiiClient{failures: 3, err: &uiiStatusError{StatusCode: http.StatusServiceUnavailable}}
+	c, delays := newTestRetryingClient(flaky, 4, 30*time.Second)
+
+	filePath := path.Join(t.TempDir(), "debian.iso")
+	assert.NoError(t, c.Build(ctx, filePath, client.BuildArgs{Hostname: "examplehost"}, client.BuildOpts{}))
+	assert.Equal(t, 4, flaky.attempts)
+	assert.Equal(t, []byte("iso for examplehost"), readFile(t, filePath))
+
+	// the backoff doubles with every attempt
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, *delays)
+
+	entries, err := tflogtest.MultilineJSONDecode(&logs)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+	for i, entry := range entries[:3] {
+		assert.Equal(t, "UII request failed, retrying", entry["@message"])
+		assert.Equal(t, "build examplehost", entry["request"])
+		assert.Equal(t, float64(i+1), entry["attempt"])
+		assert.Equal(t, float64(4), entry["max_attempts"])
+		assert.Equal(t, (*delays)[i].String(), entry["delay"])
+		assert.Contains(t, entry["error"], "503")
+	}
+	assert.Equal(t, "UII request succeeded after retrying", entries[3]["@message"])
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	transient := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	flaky := &flakyUiiClient{failures: 10, err: transient}
+	c, delays := newTestRetryingClient(flaky, 3, 30*time.Second)
+
+	_, err := c.OperatingSystems(context.Background())
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, 3, flaky.attempts)
+	assert.Len(t, *delays, 2)
+
+	// a single attempt disables the retries
+	flaky = &flakyUiiClient{failures: 10, err: transient}
+	c, delays = newTestRetryingClient(flaky, 1, 30*time.Second)
+	_, err = c.OperatingSystems(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, flaky.attempts)
+	assert.Empty(t, *delays)
+}
+
+func TestRetrySkipsPermanentErrors(t *testing.T) {
+	for _, permanent := range []error{
+		&uiiStatusError{StatusCode: http.StatusBadRequest, err: client.UIIError{Errors: []string{"unknown distribution"}}},
+		&uiiStatusError{StatusCode: http.StatusUnauthorized},
+		x509.UnknownAuthorityError{},
+		&net.OpError{Op: "remote error", Net: "tcp", Err: errors.New("tls: bad certificate")},
+		&os.PathError{Op: "write", Path: "/full/disk.iso", Err: syscall.ENOSPC},
+		errors.New("could not parse the operating systems"),
+	} {
+		flaky := &flakyUiiClient{failures: 1, err: permanent}
+		c, delays := newTestRetryingClient(flaky, 4, 30*time.Second)
+
+		err := c.Build(context.Background(), path.Join(t.TempDir(), "debian.iso"), client.BuildArgs{}, client.BuildOpts{})
+		assert.Equal(t, permanent, err)
+		assert.Equal(t, 1, flaky.attempts, permanent.Error())
+		assert.Empty(t, *delays)
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	flaky := &flakyUiiClient{failures: 10, err: &uiiStatusError{StatusCode: http.StatusServiceUnavailable}}
+	c := newRetryingUiiClient(flaky, 4, time.Minute)
+
+	// the backoff is interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.OperatingSystems(ctx)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.Equal(t, 1, flaky.attempts)
+
+	// a request failing because of the canceled context is not retried
+	flaky = &flakyUiiClient{failures: 10, err: context.Canceled}
+	c, delays := newTestRetryingClient(flaky, 4, time.Minute)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.OperatingSystems(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, flaky.attempts)
+	assert.Empty(t, *delays)
+}
+
+func TestRetryableErrors(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	for _, transient := range []error{
+		&uiiStatusError{StatusCode: http.StatusTooManyRequests},
+		&uiiStatusError{StatusCode: http.StatusInternalServerError},
+		&uiiStatusError{StatusCode: http.StatusBadGateway},
+		&uiiStatusError{StatusCode: http.StatusGatewayTimeout},
+		timeout,
+		&os.PathError{Op: "write", Path: "/tmp/debian.iso", Err: timeout},
+		&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		syscall.ECONNRESET,
+	} {
+		assert.True(t, isRetryableError(transient), transient.Error())
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	flaky := &flakyUiiClient{failures: 1, err: &uiiStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 7 * time.Second}}
+	c, delays := newTestRetryingClient(flaky, 4, 30*time.Second)
+
+	_, err := c.OperatingSystems(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{7 * time.Second}, *delays)
+
+	// a server asking for a longer delay than the maximum backoff fails the request instead of blocking the apply
+	flaky = &flakyUiiClient{failures: 1, err: &uiiStatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}}
+	c, delays = newTestRetryingClient(flaky, 4, 30*time.Second)
+
+	_, err = c.OperatingSystems(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, flaky.attempts)
+	assert.Empty(t, *delays)
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	flaky := &flakyUiiClient{failures: 5, err: &uiiStatusError{StatusCode: http.StatusBadGateway}}
+	c, delays := newTestRetryingClient(flaky, 6, 3*time.Second)
+
+	_, err := c.OperatingSystems(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second, 3 * time.Second}, *delays)
+
+	// the jitter stays between half and all of the backoff
+	for i := 0; i < 100; i++ {
+		delay := fullJitter(4 * time.Second)
+		assert.GreaterOrEqual(t, delay, 2*time.Second)
+		assert.LessOrEqual(t, delay, 4*time.Second)
+	}
+}
+
+func TestRetryAgainstStandIn(t *testing.T) {
+	var requests int
+	server := newRetryStandIn(t, func(w http.ResponseWriter) bool {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(client.UIIError{Errors: []string{"maintenance"}, StatusCode: http.StatusServiceUnavailable})
+			return true
+		}
+
+		return false
+	})
+
+	uii, err := newUiiHTTPClient(standInToken, EndpointConfig{URL: server + "/uii"})
+	assert.NoError(t, err)
+
+	var delays []time.Duration
+	c := newRetryingUiiClient(uii, 0, 0)
+	c.sleep = func(_ context.Context, delay time.Duration) error {
+		delays = append(delays, delay)
+		return nil
+	}
+
+	filePath := path.Join(t.TempDir(), "debian.iso")
+	assert.NoError(t, c.Build(context.Background(), filePath, client.BuildArgs{Hostname: "examplehost"}, client.BuildOpts{}))
+	assert.Equal(t, []byte("iso for examplehost"), readFile(t, filePath))
+	assert.Equal(t, []time.Duration{2 * time.Second}, delays)
+	assert.Equal(t, 2, requests)
+}
+
+// newRetryStandIn starts a UII stand-in whose responses can be replaced by the intercept function
+func newRetryStandIn(t *testing.T, intercept func(w http.ResponseWriter) bool) string {
+	standIn, server := newUiiStandIn(t)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !intercept(w) {
+			standIn.ServeHTTP(w, r)
+		}
+	})
+
+	return server.URL
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Wed, 01 May 2024 12:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Wed, 01 May 2024 11:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
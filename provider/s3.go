@@ -0,0 +1,450 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrObjectStorage   = errors.New("object storage request failed")
+	ErrObjectNotFound  = errors.New("object not found")
+	ErrInvalidS3Config = errors.New("invalid s3 configuration")
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// defaultS3PartSize is the size of the parts of multipart uploads, files up to this size are uploaded at once
+	defaultS3PartSize = 64 * 1024 * 1024
+	// maxPresignedURLLifetime is the longest lifetime SigV4 allows for presigned URLs
+	maxPresignedURLLifetime = 7 * 24 * time.Hour
+)
+
+// sigV4Credentials sign requests to S3 compatible object storages with AWS signature version 4
+type sigV4Credentials struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+// sign adds the signature headers to the request. All headers already set on the request are signed.
+func (c sigV4Credentials) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders, headers := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := c.scope(now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, c.AccessKey, scope, signedHeaders, c.signature(canonicalRequest, amzDate, now)))
+}
+
+// presign returns a URL granting GET access to the object for the given lifetime
+func (c sigV4Credentials) presign(objectURL *url.URL, lifetime time.Duration, now time.Time) string {
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+
+	query := objectURL.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", c.AccessKey+"/"+c.scope(now))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(lifetime.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		canonicalURI(objectURL),
+		canonicalQuery(query),
+		"host:" + objectURL.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	signed := *objectURL
+	signed.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + c.signature(canonicalRequest, amzDate, now)
+	return signed.String()
+}
+
+func (c sigV4Credentials) scope(now time.Time) string {
+	return now.UTC().Format(sigV4DateFormat) + "/" + c.Region + "/s3/aws4_request"
+}
+
+func (c sigV4Credentials) signature(canonicalRequest, amzDate string, now time.Time) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		c.scope(now),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), now.UTC().Format(sigV4DateFormat))
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalHeaders returns the sorted names and the canonical form of the host and all headers set on the request
+func canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	values := map[string]string{"host": host}
+	for name, value := range req.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" {
+			continue
+		}
+
+		trimmed := make([]string, len(value))
+		for i := range value {
+			trimmed[i] = strings.Join(strings.Fields(value[i]), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+
+	return strings.Join(names, ";"), headers.String()
+}
+
+// canonicalURI encodes every path segment of the URL as required by SigV4
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+
+	return uriEncode(u.Path, false)
+}
+
+// canonicalQuery encodes and sorts the query parameters as required by SigV4
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and slashes if encodeSlash is false
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return encoded.String()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Client is a minimal client for S3 compatible object storages. Objects are addressed path-style, as
+// self-hosted storages usually don't resolve bucket subdomains.
+type s3Client struct {
+	endpoint    *url.URL
+	bucket      string
+	credentials sigV4Credentials
+	partSize    int64
+	httpClient  *http.Client
+	now         func() time.Time
+}
+
+// newS3Client creates a client for the bucket on the endpoint
+func newS3Client(endpoint, bucket string, credentials sigV4Credentials) (*s3Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%w: endpoint %q is not an absolute URL", ErrInvalidS3Config, endpoint)
+	}
+
+	if bucket == "" {
+		return nil, fmt.Errorf("%w: bucket not set", ErrInvalidS3Config)
+	}
+
+	return &s3Client{
+		endpoint:    u,
+		bucket:      bucket,
+		credentials: credentials,
+		partSize:    defaultS3PartSize,
+		httpClient:  http.DefaultClient,
+		now:         time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of the object with the given key
+func (c *s3Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket + "/" + key
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = ""
+	return &u
+}
+
+// PresignGet returns a URL granting GET access to the object for the given lifetime
+func (c *s3Client) PresignGet(key string, lifetime time.Duration) string {
+	return c.credentials.presign(c.objectURL(key), lifetime, c.now())
+}
+
+// PutObject uploads the data as object with the given key
+func (c *s3Client) PutObject(ctx context.Context, key string, data []byte) error {
+	_, err := c.do(ctx, http.MethodPut, key, nil, data)
+	return err
+}
+
+// GetObject downloads the object with the given key
+func (c *s3Client) GetObject(ctx context.Context, key string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, key, nil, nil)
+}
+
+// ObjectExists checks if an object with the given key exists
+func (c *s3Client) ObjectExists(ctx context.Context, key string) (bool, error) {
+	_, err := c.do(ctx, http.MethodHead, key, nil, nil)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// DeleteObject removes the object with the given key. Missing objects are ignored.
+func (c *s3Client) DeleteObject(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, key, nil, nil)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// UploadFile uploads the file as object with the given key. Files larger than the part size are uploaded in parts,
+// so that only one part at a time is kept in memory. The upload is aborted once the context is done.
+func (c *s3Client) UploadFile(ctx context.Context, key, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() <= c.partSize {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.do(ctx, http.MethodPut, key, nil, data)
+		return err
+	}
+
+	return c.multipartUpload(ctx, key, file)
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (c *s3Client) multipartUpload(ctx context.Context, key string, file io.Reader) error {
+	body, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+
+	var initiated initiateMultipartUploadResult
+	if err := xml.Unmarshal(body, &initiated); err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("%w: invalid response to multipart upload of %s", ErrObjectStorage, key)
+	}
+
+	err = c.uploadParts(ctx, key, initiated.UploadID, file)
+	if err != nil {
+		// don't leave the uploaded parts behind, they are billed until aborted. Also if the upload was canceled.
+		_, _ = c.do(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil)
+	}
+
+	return err
+}
+
+func (c *s3Client) uploadParts(ctx context.Context, key, uploadID string, file io.Reader) error {
+	var completed completeMultipartUpload
+	buffer := make([]byte, c.partSize)
+
+	for partNumber := 1; ; partNumber++ {
+		n, err := io.ReadFull(file, buffer)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		etag, err := c.uploadPart(ctx, key, uploadID, partNumber, buffer[:n])
+		if err != nil {
+			return err
+		}
+
+		completed.Parts = append(completed.Parts, completedPart{PartNumber: partNumber, ETag: etag})
+	}
+
+	data, err := xml.Marshal(completed)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, data)
+	if err != nil {
+		return err
+	}
+
+	// completing can fail after the response status was sent
+	if bytes.Contains(body, []byte("<Error>")) {
+		return fmt.Errorf("%w: completing multipart upload of %s: %s", ErrObjectStorage, key, string(body))
+	}
+
+	return nil
+}
+
+func (c *s3Client) uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {uploadID},
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, key, query, data)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+// do sends a signed request and returns the response body
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, data []byte) ([]byte, error) {
+	req, err := c.newRequest(ctx, method, key, query, data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.body, nil
+}
+
+func (c *s3Client) newRequest(ctx context.Context, method, key string, query url.Values, data []byte) (*http.Request, error) {
+	u := c.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	c.credentials.sign(req, sha256Hex(data), c.now())
+	return req, nil
+}
+
+type s3Response struct {
+	*http.Response
+	body []byte
+}
+
+func (c *s3Client) send(req *http.Request) (s3Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return s3Response{}, fmt.Errorf("%w: %s", ErrObjectStorage, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return s3Response{}, fmt.Errorf("%w: %s", ErrObjectStorage, err.Error())
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return s3Response{}, fmt.Errorf("%w: %s", ErrObjectNotFound, req.URL.Path)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return s3Response{}, fmt.Errorf("%w: %s %s returned %s: %s", ErrObjectStorage, req.Method, req.URL.Path, resp.Status, string(body))
+	}
+
+	return s3Response{Response: resp, body: body}, nil
+}
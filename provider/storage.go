@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	storageKey        = "storage"
+	storageBackendKey = "backend"
+)
+
+const (
+	// storageBackendBolt keeps the ISO records in a bolt database in the storage folder
+	storageBackendBolt = "bolt"
+	// storageBackendDirectory keeps every ISO record in its own JSON file, which needs no file lock
+	storageBackendDirectory = "directory"
+
+	defaultStorageBackend = storageBackendBolt
+)
+
+// Store is an interface for persisting ISO records and their files
+type Store interface {
+	// ReadIso returns the record of the ISO with the given id, or ErrIsoNotFound
+	ReadIso(ctx context.Context, isoID string) (StoredIso, error)
+	// WriteIso creates or replaces the record of the ISO
+	WriteIso(ctx context.Context, iso StoredIso) error
+	// DeleteIso removes the record of the ISO with the given id
+	DeleteIso(ctx context.Context, isoID string) error
+	// ListIsos returns the records of all ISOs
+	ListIsos() ([]StoredIso, error)
+	// TouchIso sets the access time of the ISO with the given id
+	TouchIso(isoID string, accessTime time.Time) error
+
+	// IsoFilePath returns the path the ISO file with the given name is built to
+	IsoFilePath(name string) string
+	// SaveIsoFile stores the ISO file that was built to the LocalPath of the ISO and returns the updated record. Uploads
+	// are aborted once the context is done.
+	SaveIsoFile(ctx context.Context, iso StoredIso) (StoredIso, error)
+	// IsoFileExists checks if the file of the ISO is present
+	IsoFileExists(ctx context.Context, iso StoredIso) (bool, error)
+	// DeleteIsoFile removes the file of the ISO. Missing files are ignored.
+	DeleteIsoFile(ctx context.Context, iso StoredIso) error
+	// RenewIsoURLs renews the URLs of the ISO file that expire soon, stores the renewed record and returns it
+	RenewIsoURLs(ctx context.Context, iso StoredIso) (StoredIso, error)
+
+	// BlobPath returns the path of the blob with the given key in the content-addressed cache
+	BlobPath(blobKey string) string
+	// ReferenceBlob adds delta to the reference count of the blob and returns the new count
+	ReferenceBlob(blobKey string, delta int) (int, error)
+
+	// Close releases the storage
+	Close() error
+}
+
+// StorageConfig selects and configures the Store of the provider
+type StorageConfig struct {
+	Backend     string
+	Folder      string
+	LockTimeout time.Duration
+	// S3 optionally uploads the ISOs to an object storage
+	S3 *S3Config
+	// EncryptionKey optionally encrypts the records, PreviousEncryptionKeys decrypt records of rotated keys
+	EncryptionKey          string
+	PreviousEncryptionKeys []string
+}
+
+// newStore opens the Store selected by the config
+func newStore(config StorageConfig) (Store, error) {
+	if config.Folder == "" {
+		return nil, ErrStoragePathNotSet
+	}
+
+	codec, err := newRecordCodec(config.EncryptionKey, config.PreviousEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// the records and ISOs contain secrets, folders created by older versions were readable by everyone
+	if err := os.Chmod(config.Folder, 0700); err != nil {
+		//nolint: errorlint // can't have two errors
+		return nil, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	var store Store
+
+	switch config.Backend {
+	case storageBackendBolt, "":
+		store, err = newBoltStore(config.Folder, config.LockTimeout, codec)
+	case storageBackendDirectory:
+		store, err = newDirectoryStore(config.Folder, codec)
+	default:
+		err = validateStorageBackend(config.Backend)
+	}
+
+	if err != nil || config.S3 == nil {
+		return store, err
+	}
+
+	s3, err := newS3Store(store, *config.S3, codec)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	return s3, nil
+}
+
+// blobsFolderName is the folder in the storage folder holding the content-addressed ISO files
+const blobsFolderName = "blobs"
+
+// localIsoFiles stores ISO files in a local folder - shared by all backends, as UII clients download to local files.
+// Every distinct ISO is stored once as blob, the files of the resources link to it.
+type localIsoFiles struct {
+	folder string
+}
+
+// newLocalIsoFiles creates the blobs folder in the storage folder
+func newLocalIsoFiles(folder string) (localIsoFiles, error) {
+	blobsFolder := path.Join(folder, blobsFolderName)
+	err := os.MkdirAll(blobsFolder, 0700)
+	if err == nil {
+		// the mode only applies to new folders
+		err = os.Chmod(blobsFolder, 0700)
+	}
+
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return localIsoFiles{}, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	return localIsoFiles{folder: folder}, nil
+}
+
+// BlobPath returns the path of the blob with the given key
+func (f localIsoFiles) BlobPath(blobKey string) string {
+	return path.Join(f.folder, blobsFolderName, blobKey)
+}
+
+// IsoFilePath returns the path the ISO file with the given name is built to
+func (f localIsoFiles) IsoFilePath(name string) string {
+	return path.Join(f.folder, name+".iso")
+}
+
+// SaveIsoFile does nothing, as the ISO file was already built to its final location
+func (f localIsoFiles) SaveIsoFile(_ context.Context, iso StoredIso) (StoredIso, error) {
+	return iso, nil
+}
+
+// RenewIsoURLs does nothing, as local ISO files have no URLs
+func (f localIsoFiles) RenewIsoURLs(_ context.Context, iso StoredIso) (StoredIso, error) {
+	return iso, nil
+}
+
+// IsoFileExists checks if the file of the ISO is present on disk
+func (f localIsoFiles) IsoFileExists(_ context.Context, iso StoredIso) (bool, error) {
+	_, err := os.Stat(iso.LocalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// DeleteIsoFile removes the file of the ISO from disk
+func (f localIsoFiles) DeleteIsoFile(_ context.Context, iso StoredIso) error {
+	if iso.LocalPath == "" {
+		return nil
+	}
+
+	err := os.Remove(iso.LocalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,280 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore keeps the ISO records in a bolt database and the ISO files next to it. The database is opened once and
+// locked against other processes until the store is closed.
+type boltStore struct {
+	localIsoFiles
+	db    *bolt.DB
+	codec *recordCodec
+}
+
+// newBoltStore opens the database in the folder, waiting at most lockTimeout for other processes to release it
+func newBoltStore(folder string, lockTimeout time.Duration, codec *recordCodec) (*boltStore, error) {
+	if lockTimeout <= 0 {
+		lockTimeout = defaultLockTimeout
+	}
+
+	dbPath := path.Join(folder, DataBaseName)
+	db, err := setupDB(dbPath, lockTimeout, codec)
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%w: %s is still locked after %s", ErrStorageLocked, folder, lockTimeout)
+	}
+
+	if errors.Is(err, ErrStorageCorrupt) || errors.Is(err, ErrStorageVersion) || errors.Is(err, ErrEncryptionKeyMissing) {
+		return nil, fmt.Errorf("%s: %w", dbPath, err)
+	}
+
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return nil, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	files, err := newLocalIsoFiles(folder)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &boltStore{
+		localIsoFiles: files,
+		db:            db,
+		codec:         codec,
+	}, nil
+}
+
+// ReadIso returns the record of the ISO with the given id
+func (s *boltStore) ReadIso(_ context.Context, isoID string) (StoredIso, error) {
+	return readIso(s.db, s.codec, isoID)
+}
+
+// WriteIso creates or replaces the record of the ISO
+func (s *boltStore) WriteIso(_ context.Context, iso StoredIso) error {
+	return updateIso(s.db, s.codec, iso.ID, iso)
+}
+
+// DeleteIso removes the record of the ISO with the given id
+func (s *boltStore) DeleteIso(_ context.Context, isoID string) error {
+	return deleteIso(s.db, isoID)
+}
+
+// ListIsos returns the records of all ISOs in the ISOS bucket
+func (s *boltStore) ListIsos() ([]StoredIso, error) {
+	var isos []StoredIso
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS"))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+
+		return b.ForEach(func(key, rawData []byte) error {
+			iso, err := s.codec.Unmarshal(string(key), rawData)
+			if err != nil {
+				return err
+			}
+
+			isos = append(isos, iso)
+			return nil
+		})
+	})
+
+	return isos, err
+}
+
+// TouchIso sets the access time in the record of the ISO
+func (s *boltStore) TouchIso(isoID string, accessTime time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS"))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+
+		rawData := b.Get([]byte(isoID))
+		if rawData == nil {
+			return fmt.Errorf("%w: %s", ErrIsoNotFound, isoID)
+		}
+
+		iso, err := s.codec.Unmarshal(isoID, rawData)
+		if err != nil {
+			return err
+		}
+
+		iso.AccessTime = accessTime
+		entryBytes, err := s.codec.Marshal(iso)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(isoID), entryBytes)
+	})
+}
+
+// ReferenceBlob adds delta to the reference count of the blob in the BLOBS bucket
+func (s *boltStore) ReferenceBlob(blobKey string, delta int) (references int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("DB")).Bucket([]byte("BLOBS"))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+
+		if raw := b.Get([]byte(blobKey)); raw != nil {
+			references, err = strconv.Atoi(string(raw))
+			if err != nil {
+				return fmt.Errorf("invalid reference count of blob %s: %w", blobKey, err)
+			}
+		}
+
+		references += delta
+		if references <= 0 {
+			return b.Delete([]byte(blobKey))
+		}
+
+		return b.Put([]byte(blobKey), []byte(strconv.Itoa(references)))
+	})
+
+	return references, err
+}
+
+// Close releases the database, so that other processes can use the storage folder
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func setupDB(dbPath string, lockTimeout time.Duration, codec *recordCodec) (*bolt.DB, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: lockTimeout})
+	if errors.Is(err, bolt.ErrInvalid) || errors.Is(err, bolt.ErrChecksum) || errors.Is(err, bolt.ErrVersionMismatch) {
+		//nolint: errorlint // can't have two errors
+		return nil, fmt.Errorf("%w: %s", ErrStorageCorrupt, err.Error())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open db, %w", err)
+	}
+
+	// the mode only applies to new databases
+	if err := os.Chmod(dbPath, 0600); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not restrict permissions of db, %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte("DB"))
+		if err != nil {
+			return fmt.Errorf("could not create root bucket: %w", err)
+		}
+		_, err = root.CreateBucketIfNotExists([]byte("ISOS"))
+		if err != nil {
+			return fmt.Errorf("could not create weight bucket: %w", err)
+		}
+
+		if err := migrateDB(root, codec); err != nil {
+			return err
+		}
+
+		return rewriteRecords(root, codec)
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not set up buckets, %w", err)
+	}
+
+	return db, nil
+}
+
+func updateIso(db *bolt.DB, codec *recordCodec, isoKey string, iso StoredIso) error {
+	iso.ID = isoKey
+	entryBytes, err := codec.Marshal(iso)
+	if err != nil {
+		return err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS")).Put([]byte(isoKey), entryBytes)
+		if err != nil {
+			return fmt.Errorf("could not insert iso: %w", err)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func readIso(db *bolt.DB, codec *recordCodec, isoKey string) (isoData StoredIso, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS"))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		rawData := b.Get([]byte(isoKey))
+		if rawData == nil {
+			return fmt.Errorf("%w: %s", ErrIsoNotFound, isoKey)
+		}
+		isoData, err = codec.Unmarshal(isoKey, rawData)
+		return err
+	})
+	return isoData, err
+}
+
+// rewriteRecords encrypts plain records and records encrypted with a previous key with the current key. Without a
+// current key encrypted records are decrypted.
+func rewriteRecords(root *bolt.Bucket, codec *recordCodec) error {
+	isos := root.Bucket([]byte("ISOS"))
+	if isos == nil {
+		return ErrBucketNotFound
+	}
+
+	rewritten := map[string][]byte{}
+	err := isos.ForEach(func(key, rawData []byte) error {
+		if !codec.NeedsRewrite(rawData) {
+			return nil
+		}
+
+		iso, err := codec.Unmarshal(string(key), rawData)
+		if err != nil {
+			return err
+		}
+
+		entryBytes, err := codec.Marshal(iso)
+		rewritten[string(key)] = entryBytes
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// buckets must not be changed while iterating them
+	for key, entryBytes := range rewritten {
+		if err := isos.Put([]byte(key), entryBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// corruptRecordError describes a record that can't be unmarshalled
+func corruptRecordError(isoKey string, err error) error {
+	//nolint: errorlint // can't have two errors
+	return fmt.Errorf("%w: record of iso %s: %s", ErrStorageCorrupt, isoKey, err.Error())
+}
+
+func deleteIso(db *bolt.DB, isoKey string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("DB")).Bucket([]byte("ISOS"))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		return b.Delete([]byte(isoKey))
+	})
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// schemaVersionKey is the key of the schema version in the META bucket. Databases without it have version 0, the
+// format before versioning.
+const schemaVersionKey = "schema_version"
+
+// migration upgrades the database by one schema version. It runs in the transaction that opens the database.
+type migration struct {
+	description string
+	migrate     func(root *bolt.Bucket, codec *recordCodec) error
+}
+
+// migrations upgrade the database from the schema version of their index to the next one. New migrations are appended,
+// released ones must not change.
+var migrations = []migration{
+	{"compute the fingerprints of ISOs stored before fingerprints existed", addFingerprints},
+	{"create the bucket of the blob reference counts", createBlobsBucket},
+}
+
+// schemaVersion is the version of databases written by this provider
+func schemaVersion() int {
+	return len(migrations)
+}
+
+// migrateDB brings the database in the root bucket to the current schema version
+func migrateDB(root *bolt.Bucket, codec *recordCodec) error {
+	meta, err := root.CreateBucketIfNotExists([]byte("META"))
+	if err != nil {
+		return fmt.Errorf("could not create meta bucket: %w", err)
+	}
+
+	version := 0
+	if raw := meta.Get([]byte(schemaVersionKey)); raw != nil {
+		version, err = strconv.Atoi(string(raw))
+		if err != nil || version < 0 {
+			return fmt.Errorf("%w: invalid schema version %q", ErrStorageCorrupt, raw)
+		}
+	}
+
+	if version > schemaVersion() {
+		return fmt.Errorf("%w: schema version %d, this provider supports up to %d", ErrStorageVersion, version, schemaVersion())
+	}
+
+	for i := version; i < schemaVersion(); i++ {
+		if err := migrations[i].migrate(root, codec); err != nil {
+			return fmt.Errorf("could not migrate to schema version %d, %s: %w", i+1, migrations[i].description, err)
+		}
+	}
+
+	return meta.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(schemaVersion())))
+}
+
+// addFingerprints sets the fingerprints of ISOs stored without one. The records hold the inputs the files were built
+// with, so that the ISOs are not rebuilt just because the fingerprint is missing.
+func addFingerprints(root *bolt.Bucket, codec *recordCodec) error {
+	isos := root.Bucket([]byte("ISOS"))
+	if isos == nil {
+		return ErrBucketNotFound
+	}
+
+	migrated := map[string][]byte{}
+	err := isos.ForEach(func(key, rawData []byte) error {
+		iso, err := codec.Unmarshal(string(key), rawData)
+		if err != nil {
+			return err
+		}
+
+		if iso.Fingerprint != "" {
+			return nil
+		}
+
+		iso.Fingerprint = isoFingerprint(iso.Iso)
+		entryBytes, err := codec.Marshal(iso)
+		if err != nil {
+			return err
+		}
+
+		migrated[string(key)] = entryBytes
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// buckets must not be changed while iterating them
+	for key, entryBytes := range migrated {
+		if err := isos.Put([]byte(key), entryBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createBlobsBucket creates the bucket of the reference counts of the content-addressed cache
+func createBlobsBucket(root *bolt.Bucket, _ *recordCodec) error {
+	_, err := root.CreateBucketIfNotExists([]byte("BLOBS"))
+	return err
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// openFixture opens a copy of a database in the testdata folder
+func openFixture(t *testing.T, fixture string) (Store, error) {
+	data, err := os.ReadFile(path.Join("testdata", fixture))
+	assert.NoError(t, err)
+
+	folder := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(folder, DataBaseName), data, 0600))
+
+	store, err := newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+	if err == nil {
+		t.Cleanup(func() { _ = store.Close() })
+	}
+
+	return store, err
+}
+
+func storedSchemaVersion(t *testing.T, store Store) string {
+	var version []byte
+	boltStore, _ := store.(*boltStore)
+	assert.NoError(t, boltStore.db.View(func(tx *bolt.Tx) error {
+		version = tx.Bucket([]byte("DB")).Bucket([]byte("META")).Get([]byte(schemaVersionKey))
+		return nil
+	}))
+
+	return string(version)
+}
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	// a cache of the first provider release, without fingerprints and blobs
+	store, err := openFixture(t, "uii_baseline.db")
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(schemaVersion()), storedSchemaVersion(t, store))
+
+	iso, err := store.ReadIso(context.Background(), "debian_iso")
+	assert.NoError(t, err)
+	assert.Equal(t, "examplehost", iso.HostName)
+	assert.Equal(t, "/tmp/uiiterraform/debian_iso.iso", iso.LocalPath)
+	assert.Equal(t, isoFingerprint(testIso("debian_iso")), iso.Fingerprint)
+	assert.Empty(t, iso.BlobKey)
+
+	references, err := store.ReferenceBlob(iso.Fingerprint, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, references)
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	// a cache written before the schema version was stored
+	store, err := openFixture(t, "uii_unversioned.db")
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(schemaVersion()), storedSchemaVersion(t, store))
+
+	iso, err := store.ReadIso(context.Background(), "debian_iso")
+	assert.NoError(t, err)
+	assert.Equal(t, rebuildNever, iso.Rebuild.Policy)
+	assert.Equal(t, isoFingerprint(testIso("debian_iso")), iso.Fingerprint)
+	assert.Equal(t, iso.Fingerprint, iso.BlobKey)
+
+	references, err := store.ReferenceBlob(iso.BlobKey, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, references)
+}
+
+func TestMigrationsRunOnce(t *testing.T) {
+	folder := t.TempDir()
+	store, err := newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+	assert.NoError(t, err)
+
+	iso := StoredIso{ID: "debian_iso", Iso: testIso("debian_iso")}
+	assert.NoError(t, store.WriteIso(context.Background(), iso))
+	assert.NoError(t, store.Close())
+
+	// a current database is not migrated again, the missing fingerprint stays missing
+	store, err = newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+	assert.NoError(t, err)
+	defer store.Close()
+
+	read, err := store.ReadIso(context.Background(), "debian_iso")
+	assert.NoError(t, err)
+	assert.Empty(t, read.Fingerprint)
+}
+
+func TestOpenUnreadableDatabase(t *testing.T) {
+	writeMeta := func(t *testing.T, folder string, update func(root *bolt.Bucket) error) {
+		db, err := setupDB(path.Join(folder, DataBaseName), defaultLockTimeout, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			return update(tx.Bucket([]byte("DB")))
+		}))
+		assert.NoError(t, db.Close())
+	}
+
+	t.Run("future version", func(t *testing.T) {
+		folder := t.TempDir()
+		writeMeta(t, folder, func(root *bolt.Bucket) error {
+			return root.Bucket([]byte("META")).Put([]byte(schemaVersionKey), []byte(strconv.Itoa(schemaVersion()+1)))
+		})
+
+		_, err := newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+		assert.ErrorIs(t, err, ErrStorageVersion)
+		assert.Contains(t, err.Error(), path.Join(folder, DataBaseName))
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		folder := t.TempDir()
+		writeMeta(t, folder, func(root *bolt.Bucket) error {
+			return root.Bucket([]byte("META")).Put([]byte(schemaVersionKey), []byte("two"))
+		})
+
+		_, err := newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+		assert.ErrorIs(t, err, ErrStorageCorrupt)
+	})
+
+	t.Run("invalid record", func(t *testing.T) {
+		folder := t.TempDir()
+		writeMeta(t, folder, func(root *bolt.Bucket) error {
+			return root.Bucket([]byte("ISOS")).Put([]byte("debian_iso"), []byte("{not json"))
+		})
+
+		store, err := newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+		assert.NoError(t, err)
+		defer store.Close()
+
+		_, err = store.ReadIso(context.Background(), "debian_iso")
+		assert.ErrorIs(t, err, ErrStorageCorrupt)
+		assert.Contains(t, err.Error(), "debian_iso")
+
+		_, err = store.ListIsos()
+		assert.ErrorIs(t, err, ErrStorageCorrupt)
+	})
+
+	t.Run("no database", func(t *testing.T) {
+		folder := t.TempDir()
+		assert.NoError(t, os.WriteFile(path.Join(folder, DataBaseName), []byte("this is not a bolt database, just some text"), 0600))
+
+		_, err := newStore(StorageConfig{Backend: storageBackendBolt, Folder: folder})
+		assert.ErrorIs(t, err, ErrStorageCorrupt)
+	})
+}
@@ -0,0 +1,250 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordsFolderName is the folder in the storage folder holding the JSON records of the directory backend
+const recordsFolderName = "records"
+
+// referencesFolderName is the folder in the storage folder holding the reference counts of the blobs
+const referencesFolderName = "references"
+
+// directoryStore keeps every ISO record in its own JSON file. It needs no database lock, which makes it suitable for
+// CI runs with a fresh storage folder, but it does not protect against concurrent writes by other processes.
+type directoryStore struct {
+	localIsoFiles
+	recordsFolder    string
+	referencesFolder string
+	codec            *recordCodec
+
+	// referencesMutex serializes the updates of reference counts
+	referencesMutex sync.Mutex
+}
+
+// newDirectoryStore creates the records folder in the storage folder and brings the records to the encryption of the
+// codec
+func newDirectoryStore(folder string, codec *recordCodec) (*directoryStore, error) {
+	recordsFolder := path.Join(folder, recordsFolderName)
+	referencesFolder := path.Join(folder, referencesFolderName)
+	for _, subFolder := range []string{recordsFolder, referencesFolder} {
+		err := os.MkdirAll(subFolder, 0700)
+		if err == nil {
+			// the mode only applies to new folders
+			err = os.Chmod(subFolder, 0700)
+		}
+
+		if err != nil {
+			//nolint: errorlint // can't have two errors
+			return nil, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+		}
+	}
+
+	files, err := newLocalIsoFiles(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &directoryStore{
+		localIsoFiles:    files,
+		recordsFolder:    recordsFolder,
+		referencesFolder: referencesFolder,
+		codec:            codec,
+	}
+
+	return store, store.rewriteRecords()
+}
+
+// ReadIso returns the record of the ISO with the given id
+func (s *directoryStore) ReadIso(_ context.Context, isoID string) (StoredIso, error) {
+	var iso StoredIso
+
+	data, err := os.ReadFile(s.recordPath(isoID))
+	if errors.Is(err, os.ErrNotExist) {
+		return iso, fmt.Errorf("%w: %s", ErrIsoNotFound, isoID)
+	}
+
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return iso, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	return s.codec.Unmarshal(isoID, data)
+}
+
+// WriteIso creates or replaces the record of the ISO. The record is written to a temporary file first, so that
+// readers never see a partial record.
+func (s *directoryStore) WriteIso(_ context.Context, iso StoredIso) error {
+	data, err := s.codec.Marshal(iso)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.recordsFolder, iso.ID+".*.tmp")
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.recordPath(iso.ID))
+	}
+
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	return nil
+}
+
+// DeleteIso removes the record of the ISO with the given id
+func (s *directoryStore) DeleteIso(_ context.Context, isoID string) error {
+	err := os.Remove(s.recordPath(isoID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	return nil
+}
+
+// ListIsos returns the records of all ISOs in the records folder
+func (s *directoryStore) ListIsos() ([]StoredIso, error) {
+	entries, err := os.ReadDir(s.recordsFolder)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return nil, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	var isos []StoredIso
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			// temporary files of concurrent writes
+			continue
+		}
+
+		iso, err := s.ReadIso(context.Background(), strings.TrimSuffix(entry.Name(), ".json"))
+		if errors.Is(err, ErrIsoNotFound) {
+			// deleted in the meantime
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		isos = append(isos, iso)
+	}
+
+	return isos, nil
+}
+
+// TouchIso sets the access time in the record of the ISO
+func (s *directoryStore) TouchIso(isoID string, accessTime time.Time) error {
+	iso, err := s.ReadIso(context.Background(), isoID)
+	if err != nil {
+		return err
+	}
+
+	iso.AccessTime = accessTime
+	return s.WriteIso(context.Background(), iso)
+}
+
+// ReferenceBlob adds delta to the reference count of the blob, which is kept in a file named after the blob
+func (s *directoryStore) ReferenceBlob(blobKey string, delta int) (int, error) {
+	s.referencesMutex.Lock()
+	defer s.referencesMutex.Unlock()
+
+	referencesPath := path.Join(s.referencesFolder, blobKey)
+	references := 0
+
+	data, err := os.ReadFile(referencesPath)
+	if err == nil {
+		references, err = strconv.Atoi(string(data))
+		if err != nil {
+			return 0, fmt.Errorf("invalid reference count of blob %s: %w", blobKey, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		//nolint: errorlint // can't have two errors
+		return 0, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	references += delta
+	if references <= 0 {
+		err = os.Remove(referencesPath)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(referencesPath, []byte(strconv.Itoa(references)), 0600)
+	}
+
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return 0, fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	return references, nil
+}
+
+// rewriteRecords encrypts plain records and records encrypted with a previous key with the current key. Without a
+// current key encrypted records are decrypted.
+func (s *directoryStore) rewriteRecords() error {
+	entries, err := os.ReadDir(s.recordsFolder)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		isoID := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := os.ReadFile(s.recordPath(isoID))
+		if err != nil {
+			//nolint: errorlint // can't have two errors
+			return fmt.Errorf("%w: %s", ErrStorage, err.Error())
+		}
+
+		if !s.codec.NeedsRewrite(data) {
+			continue
+		}
+
+		iso, err := s.codec.Unmarshal(isoID, data)
+		if err != nil {
+			return err
+		}
+
+		if err := s.WriteIso(context.Background(), iso); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close does nothing, as no files are kept open
+func (s *directoryStore) Close() error {
+	return nil
+}
+
+func (s *directoryStore) recordPath(isoID string) string {
+	return path.Join(s.recordsFolder, isoID+".json")
+}
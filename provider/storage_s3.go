@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	s3Key                       = "s3"
+	s3EndpointKey               = "endpoint"
+	s3RegionKey                 = "region"
+	s3BucketKey                 = "bucket"
+	s3PrefixKey                 = "prefix"
+	s3AccessKeyKey              = "access_key"
+	s3SecretKeyKey              = "secret_key"
+	presignedURLLifetimeKey     = "presigned_url_lifetime"
+	S3AccessKeyEnvName          = "AWS_ACCESS_KEY_ID"
+	S3SecretKeyEnvName          = "AWS_SECRET_ACCESS_KEY" // nolint: gosec // only the name
+	defaultS3Region             = "us-east-1"
+	defaultPresignedURLLifetime = 24 * time.Hour
+)
+
+// S3Config configures the upload of built ISOs to an S3 compatible object storage
+type S3Config struct {
+	Endpoint             string
+	Region               string
+	Bucket               string
+	Prefix               string
+	AccessKey            string
+	SecretKey            string
+	PresignedURLLifetime time.Duration
+}
+
+// s3Store mirrors the ISO files and records of a local store to an S3 compatible bucket. The local store stays the
+// source of truth for the records, the local ISO files are the build cache of the uploaded objects.
+type s3Store struct {
+	Store
+	client               *s3Client
+	prefix               string
+	presignedURLLifetime time.Duration
+	codec                *recordCodec
+}
+
+// newS3Store wraps the local store, uploading to the bucket configured in config. The uploaded records are encoded
+// like the local ones.
+func newS3Store(local Store, config S3Config, codec *recordCodec) (*s3Store, error) {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+
+	client, err := newS3Client(endpoint, config.Bucket, sigV4Credentials{
+		AccessKey: config.AccessKey,
+		SecretKey: config.SecretKey,
+		Region:    config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(config.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	lifetime := config.PresignedURLLifetime
+	if lifetime <= 0 {
+		lifetime = defaultPresignedURLLifetime
+	}
+
+	return &s3Store{
+		Store:                local,
+		client:               client,
+		prefix:               prefix,
+		presignedURLLifetime: lifetime,
+		codec:                codec,
+	}, nil
+}
+
+// RenewIsoURLs renews the presigned URL once half of its lifetime has passed, the renewed record is uploaded as well
+func (s *s3Store) RenewIsoURLs(ctx context.Context, iso StoredIso) (StoredIso, error) {
+	if iso.ObjectURL == "" {
+		return iso, nil
+	}
+
+	if s.client.now().Add(s.presignedURLLifetime / 2).Before(iso.PresignedURLExpiresAt) {
+		return iso, nil
+	}
+
+	s.presign(&iso)
+	return iso, s.WriteIso(ctx, iso)
+}
+
+// WriteIso stores the record locally and uploads it next to the ISO file
+func (s *s3Store) WriteIso(ctx context.Context, iso StoredIso) error {
+	err := s.Store.WriteIso(ctx, iso)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.codec.Marshal(iso)
+	if err != nil {
+		return err
+	}
+
+	return s.client.PutObject(ctx, s.recordKey(iso.ID), data)
+}
+
+// DeleteIso removes the record locally and from the bucket
+func (s *s3Store) DeleteIso(ctx context.Context, isoID string) error {
+	err := s.Store.DeleteIso(ctx, isoID)
+	if err != nil {
+		return err
+	}
+
+	return s.client.DeleteObject(ctx, s.recordKey(isoID))
+}
+
+// SaveIsoFile uploads the built ISO file and sets its URLs
+func (s *s3Store) SaveIsoFile(ctx context.Context, iso StoredIso) (StoredIso, error) {
+	iso, err := s.Store.SaveIsoFile(ctx, iso)
+	if err != nil {
+		return iso, err
+	}
+
+	key := s.isoKey(iso.Name)
+	err = s.client.UploadFile(ctx, key, iso.LocalPath)
+	if ctx.Err() != nil {
+		return iso, fmt.Errorf("uploading %s: %w", key, ctx.Err())
+	}
+
+	if err != nil {
+		return iso, err
+	}
+
+	iso.ObjectURL = s.client.objectURL(key).String()
+	s.presign(&iso)
+	return iso, nil
+}
+
+// IsoFileExists checks if the ISO file is present locally and in the bucket
+func (s *s3Store) IsoFileExists(ctx context.Context, iso StoredIso) (bool, error) {
+	exists, err := s.Store.IsoFileExists(ctx, iso)
+	if err != nil || !exists {
+		return exists, err
+	}
+
+	return s.client.ObjectExists(ctx, s.isoKey(iso.Name))
+}
+
+// DeleteIsoFile removes the ISO file locally and from the bucket
+func (s *s3Store) DeleteIsoFile(ctx context.Context, iso StoredIso) error {
+	err := s.Store.DeleteIsoFile(ctx, iso)
+	if err != nil {
+		return err
+	}
+
+	return s.client.DeleteObject(ctx, s.isoKey(iso.Name))
+}
+
+func (s *s3Store) presign(iso *StoredIso) {
+	iso.PresignedURL = s.client.PresignGet(s.isoKey(iso.Name), s.presignedURLLifetime)
+	iso.PresignedURLExpiresAt = s.client.now().Add(s.presignedURLLifetime)
+}
+
+func (s *s3Store) isoKey(name string) string {
+	return s.prefix + name + ".iso"
+}
+
+func (s *s3Store) recordKey(isoID string) string {
+	return s.prefix + isoID + ".json"
+}
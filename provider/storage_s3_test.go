@@ -0,0 +1,189 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3StoreUploadsIsos(t *testing.T) {
+	fake, server := newFakeS3(t)
+	config := fake.config(server.URL)
+
+	c, builds := newTestClient(t)
+	c.S3 = &config
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/isos/terraform/debian_iso.iso", stored.ObjectURL)
+	assert.NotEmpty(t, stored.PresignedURL)
+
+	uploaded, ok := fake.object("isos/terraform/debian_iso.iso")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("iso for examplehost"), uploaded)
+
+	record, ok := fake.object("isos/terraform/debian_iso.json")
+	assert.True(t, ok)
+	var uploadedIso StoredIso
+	assert.NoError(t, json.Unmarshal(record, &uploadedIso))
+	assert.Equal(t, stored.Fingerprint, uploadedIso.Fingerprint)
+
+	resp, err := http.Get(stored.PresignedURL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// updates without rebuild keep the URLs
+	iso := testIso("debian_iso")
+	iso.Rebuild.Policy = rebuildNever
+	assert.NoError(t, c.UpdateIso(context.Background(), stored.ID, iso, false))
+	assert.Len(t, builds.builds, 1)
+
+	updated, err := c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.ObjectURL, updated.ObjectURL)
+	assert.Equal(t, stored.PresignedURL, updated.PresignedURL)
+
+	// a missing object counts as missing file
+	fake.mu.Lock()
+	delete(fake.objects, "isos/terraform/debian_iso.iso")
+	fake.mu.Unlock()
+	exists, err := c.IsoFileExists(context.Background(), updated)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = c.RebuildIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	_, ok = fake.object("isos/terraform/debian_iso.iso")
+	assert.True(t, ok)
+
+	assert.NoError(t, c.DeleteIso(context.Background(), stored.ID))
+	assert.Empty(t, fake.objects)
+}
+
+func TestS3StoreRenewsPresignedURLs(t *testing.T) {
+	fake, server := newFakeS3(t)
+	config := fake.config(server.URL)
+	config.PresignedURLLifetime = time.Hour
+
+	c, _ := newTestClient(t)
+	c.S3 = &config
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	store, err := c.openStore()
+	assert.NoError(t, err)
+	s3, _ := store.(*s3Store)
+	now := time.Now()
+
+	s3.client.now = func() time.Time { return now.Add(20 * time.Minute) }
+	read, err := c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.PresignedURL, read.PresignedURL)
+
+	s3.client.now = func() time.Time { return now.Add(40 * time.Minute) }
+
+	// plain reads of the record, like imports and scans of the storage, don't renew it
+	looked, err := c.LookupIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.PresignedURL, looked.PresignedURL)
+	record, err := store.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.PresignedURL, record.PresignedURL)
+
+	read, err = c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, stored.PresignedURL, read.PresignedURL)
+	assert.Equal(t, now.Add(100*time.Minute).Unix(), read.PresignedURLExpiresAt.Unix())
+
+	// the renewed URL is kept, locally and in the bucket
+	again, err := c.ReadIso(context.Background(), stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, read.PresignedURL, again.PresignedURL)
+
+	uploaded, ok := fake.object("isos/terraform/debian_iso.json")
+	assert.True(t, ok)
+	var uploadedIso StoredIso
+	assert.NoError(t, json.Unmarshal(uploaded, &uploadedIso))
+	assert.Equal(t, read.PresignedURL, uploadedIso.PresignedURL)
+}
+
+func TestS3StoreStopsWhenContextIsDone(t *testing.T) {
+	fake, server := newFakeS3(t)
+	config := fake.config(server.URL)
+
+	c, _ := newTestClient(t)
+	c.S3 = &config
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	store, err := c.openStore()
+	assert.NoError(t, err)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the requests to the bucket are not sent once the operation was canceled or timed out
+	_, err = store.IsoFileExists(canceled, stored)
+	assert.ErrorIs(t, err, ErrObjectStorage)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+
+	stored.Iso.HostName = "otherhost"
+	err = store.WriteIso(canceled, stored)
+	assert.ErrorIs(t, err, ErrObjectStorage)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+
+	err = store.DeleteIso(canceled, stored.ID)
+	assert.ErrorIs(t, err, ErrObjectStorage)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+
+	record, ok := fake.object("isos/terraform/debian_iso.json")
+	assert.True(t, ok)
+	assert.NotContains(t, string(record), "otherhost")
+}
+
+func TestS3StoreReportsUploadErrors(t *testing.T) {
+	fake, server := newFakeS3(t)
+	config := fake.config(server.URL)
+	config.SecretKey = "wrong"
+
+	c, _ := newTestClient(t)
+	c.S3 = &config
+
+	_, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.ErrorIs(t, err, ErrObjectStorage)
+
+	_, err = c.ReadIso(context.Background(), "debian_iso")
+	assert.ErrorIs(t, err, ErrIsoNotFound)
+
+	c, _ = newTestClient(t)
+	c.S3 = &S3Config{Endpoint: "not a url", Bucket: "isos"}
+	_, err = c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.ErrorIs(t, err, ErrInvalidS3Config)
+}
+
+func TestS3StoreUploadsEncryptedRecords(t *testing.T) {
+	fake, server := newFakeS3(t)
+	config := fake.config(server.URL)
+
+	c, _ := newTestClient(t)
+	c.S3 = &config
+	c.EncryptionKey = testEncryptionKey
+
+	stored, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.NoError(t, err)
+
+	record, ok := fake.object("isos/terraform/debian_iso.json")
+	assert.True(t, ok)
+	assert.NotContains(t, string(record), "examplehost")
+
+	codec, err := newRecordCodec(testEncryptionKey, nil)
+	assert.NoError(t, err)
+	uploadedIso, err := codec.Unmarshal(stored.ID, record)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.Fingerprint, uploadedIso.Fingerprint)
+}
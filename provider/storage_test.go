@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testStorageBackends = []string{storageBackendBolt, storageBackendDirectory}
+
+func TestStoreRecords(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			store, err := newStore(StorageConfig{Backend: backend, Folder: t.TempDir()})
+			assert.NoError(t, err)
+			defer store.Close()
+
+			_, err = store.ReadIso(context.Background(), "debian_iso")
+			assert.ErrorIs(t, err, ErrIsoNotFound)
+
+			iso := StoredIso{
+				ID:           "debian_iso",
+				Iso:          testIso("debian_iso"),
+				LocalPath:    store.IsoFilePath("debian_iso"),
+				CreationTime: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+				Fingerprint:  "abc",
+			}
+			assert.NoError(t, store.WriteIso(context.Background(), iso))
+
+			read, err := store.ReadIso(context.Background(), "debian_iso")
+			assert.NoError(t, err)
+			assert.Equal(t, iso, read)
+
+			iso.Fingerprint = "def"
+			assert.NoError(t, store.WriteIso(context.Background(), iso))
+			read, err = store.ReadIso(context.Background(), "debian_iso")
+			assert.NoError(t, err)
+			assert.Equal(t, "def", read.Fingerprint)
+
+			accessTime := time.Date(2023, 6, 2, 12, 0, 0, 0, time.UTC)
+			assert.NoError(t, store.TouchIso("debian_iso", accessTime))
+			read, err = store.ReadIso(context.Background(), "debian_iso")
+			assert.NoError(t, err)
+			assert.Equal(t, accessTime, read.AccessTime)
+			assert.ErrorIs(t, store.TouchIso("other_iso", accessTime), ErrIsoNotFound)
+
+			other := iso
+			other.ID = "other_iso"
+			assert.NoError(t, store.WriteIso(context.Background(), other))
+			all, err := store.ListIsos()
+			assert.NoError(t, err)
+			assert.Len(t, all, 2)
+
+			assert.NoError(t, store.DeleteIso(context.Background(), "debian_iso"))
+			_, err = store.ReadIso(context.Background(), "debian_iso")
+			assert.ErrorIs(t, err, ErrIsoNotFound)
+
+			// deleting twice is fine
+			assert.NoError(t, store.DeleteIso(context.Background(), "debian_iso"))
+		})
+	}
+}
+
+func TestStoreIsoFiles(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			folder := t.TempDir()
+			store, err := newStore(StorageConfig{Backend: backend, Folder: folder})
+			assert.NoError(t, err)
+			defer store.Close()
+
+			iso := StoredIso{ID: "debian_iso", LocalPath: store.IsoFilePath("debian_iso")}
+			assert.Equal(t, path.Join(folder, "debian_iso.iso"), iso.LocalPath)
+
+			exists, err := store.IsoFileExists(context.Background(), iso)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			assert.NoError(t, os.WriteFile(iso.LocalPath, []byte("iso"), 0600))
+			exists, err = store.IsoFileExists(context.Background(), iso)
+			assert.NoError(t, err)
+			assert.True(t, exists)
+
+			assert.NoError(t, store.DeleteIsoFile(context.Background(), iso))
+			assert.NoFileExists(t, iso.LocalPath)
+			assert.NoError(t, store.DeleteIsoFile(context.Background(), iso))
+		})
+	}
+}
+
+func TestClientWithStorageBackends(t *testing.T) {
+	for _, backend := range testStorageBackends {
+		t.Run(backend, func(t *testing.T) {
+			c, fake := newTestClient(t)
+			c.StorageBackend = backend
+
+			iso := testIso("debian_iso")
+			stored, err := c.CreateIso(context.Background(), iso)
+			assert.NoError(t, err)
+			assert.FileExists(t, stored.LocalPath)
+
+			iso.Optionals.Packages = []string{"vim"}
+			assert.NoError(t, c.UpdateIso(context.Background(), stored.ID, iso, false))
+			assert.Len(t, fake.builds, 2)
+
+			updated, err := c.ReadIso(context.Background(), stored.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"vim"}, updated.Optionals.Packages)
+
+			assert.NoError(t, c.DeleteIso(context.Background(), stored.ID))
+			assert.NoFileExists(t, stored.LocalPath)
+			_, err = c.ReadIso(context.Background(), stored.ID)
+			assert.ErrorIs(t, err, ErrIsoNotFound)
+		})
+	}
+
+	c, _ := newTestClient(t)
+	c.StorageBackend = "sqlite"
+	_, err := c.CreateIso(context.Background(), testIso("debian_iso"))
+	assert.ErrorIs(t, err, ErrInvalidStorageBackend)
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+const (
+	timeoutsKey      = "timeouts"
+	createTimeoutKey = "create"
+	readTimeoutKey   = "read"
+	updateTimeoutKey = "update"
+	deleteTimeoutKey = "delete"
+
+	// builds and downloads of ISOs take minutes, a refresh or update might rebuild the ISO
+	defaultCreateTimeout = 30 * time.Minute
+	defaultReadTimeout   = 30 * time.Minute
+	defaultUpdateTimeout = 30 * time.Minute
+	defaultDeleteTimeout = 5 * time.Minute
+)
+
+// timeoutsModel is the timeouts block of a resource, every operation is canceled once its timeout passed
+type timeoutsModel struct {
+	Create types.String `tfsdk:"create"`
+	Read   types.String `tfsdk:"read"`
+	Update types.String `tfsdk:"update"`
+	Delete types.String `tfsdk:"delete"`
+}
+
+// timeoutsBlock returns the schema of the timeouts block, in the format of the other Terraform providers
+func timeoutsBlock() schema.SingleNestedBlock {
+	attribute := func(operation string, defaultTimeout time.Duration) schema.StringAttribute {
+		return schema.StringAttribute{
+			Optional:            true,
+			Description:         fmt.Sprintf("How long to wait for %s, for example \"1h\". Partial ISO files are removed if the timeout passes. Defaults to %q.", operation, defaultTimeout),
+			MarkdownDescription: fmt.Sprintf("How long to wait for %s, for example `1h`. Partial ISO files are removed if the timeout passes. Defaults to `%s`.", operation, defaultTimeout),
+		}
+	}
+
+	return schema.SingleNestedBlock{
+		Description: "Timeouts of the operations on the ISO. Interrupting Terraform also cancels a running build.",
+		Attributes: map[string]schema.Attribute{
+			createTimeoutKey: attribute("the creation of the ISO", defaultCreateTimeout),
+			readTimeoutKey:   attribute("a refresh, which might rebuild the ISO", defaultReadTimeout),
+			updateTimeoutKey: attribute("an update of the ISO", defaultUpdateTimeout),
+			deleteTimeoutKey: attribute("the deletion of the ISO", defaultDeleteTimeout),
+		},
+	}
+}
+
+// validateTimeouts checks that all configured timeouts are durations
+func validateTimeouts(timeouts *timeoutsModel) []error {
+	if timeouts == nil {
+		return nil
+	}
+
+	var result []error
+	for _, timeout := range []struct {
+		key   string
+		value types.String
+	}{
+		{createTimeoutKey, timeouts.Create},
+		{readTimeoutKey, timeouts.Read},
+		{updateTimeoutKey, timeouts.Update},
+		{deleteTimeoutKey, timeouts.Delete},
+	} {
+		if err := validateDuration(stringOrDefault(timeout.value, ""), timeoutsKey+"."+timeout.key); err != nil {
+			result = append(result, err)
+		}
+	}
+
+	return result
+}
+
+// withTimeout returns a context that is canceled once the configured timeout or the default passed
+func withTimeout(ctx context.Context, timeout types.String, defaultTimeout time.Duration) (context.Context, context.CancelFunc) {
+	duration, err := time.ParseDuration(stringOrDefault(timeout, ""))
+	if err != nil || duration <= 0 {
+		duration = defaultTimeout
+	}
+
+	return context.WithTimeout(ctx, duration)
+}
+
+// createContext returns the context of the creation
+func (t *timeoutsModel) createContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t == nil {
+		return context.WithTimeout(ctx, defaultCreateTimeout)
+	}
+
+	return withTimeout(ctx, t.Create, defaultCreateTimeout)
+}
+
+// readContext returns the context of the refresh
+func (t *timeoutsModel) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t == nil {
+		return context.WithTimeout(ctx, defaultReadTimeout)
+	}
+
+	return withTimeout(ctx, t.Read, defaultReadTimeout)
+}
+
+// updateContext returns the context of the update
+func (t *timeoutsModel) updateContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t == nil {
+		return context.WithTimeout(ctx, defaultUpdateTimeout)
+	}
+
+	return withTimeout(ctx, t.Update, defaultUpdateTimeout)
+}
+
+// deleteContext returns the context of the deletion
+func (t *timeoutsModel) deleteContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t == nil {
+		return context.WithTimeout(ctx, defaultDeleteTimeout)
+	}
+
+	return withTimeout(ctx, t.Delete, defaultDeleteTimeout)
+}
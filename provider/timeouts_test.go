@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutsValidation(t *testing.T) {
+	assert.Empty(t, validateTimeouts(nil))
+	assert.Empty(t, validateTimeouts(&timeoutsModel{Create: types.StringValue("1h"), Read: types.StringNull(), Update: types.StringUnknown()}))
+
+	errs := validateTimeouts(&timeoutsModel{Create: types.StringValue("soon"), Delete: types.StringValue("-1m")})
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, ErrInvalidDuration)
+	}
+	assert.Contains(t, errs[0].Error(), "timeouts.create")
+	assert.Contains(t, errs[1].Error(), "timeouts.delete")
+}
+
+func TestTimeoutsSetDeadlines(t *testing.T) {
+	assertDeadline := func(ctx context.Context, cancel context.CancelFunc, timeout time.Duration) {
+		defer cancel()
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(timeout), deadline, time.Minute)
+	}
+
+	// without a timeouts block the defaults apply
+	var none *timeoutsModel
+	ctx, cancel := none.createContext(context.Background())
+	assertDeadline(ctx, cancel, defaultCreateTimeout)
+	ctx, cancel = none.deleteContext(context.Background())
+	assertDeadline(ctx, cancel, defaultDeleteTimeout)
+
+	timeouts := &timeoutsModel{Create: types.StringValue("2h"), Read: types.StringValue("5m"), Update: types.StringNull(), Delete: types.StringUnknown()}
+	ctx, cancel = timeouts.createContext(context.Background())
+	assertDeadline(ctx, cancel, 2*time.Hour)
+	ctx, cancel = timeouts.readContext(context.Background())
+	assertDeadline(ctx, cancel, 5*time.Minute)
+	ctx, cancel = timeouts.updateContext(context.Background())
+	assertDeadline(ctx, cancel, defaultUpdateTimeout)
+	ctx, cancel = timeouts.deleteContext(context.Background())
+	assertDeadline(ctx, cancel, defaultDeleteTimeout)
+}
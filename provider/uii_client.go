@@ -0,0 +1,276 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+)
+
+const (
+	endpointKey          = "endpoint"
+	proxyURLKey          = "proxy_url"
+	tlsKey               = "tls"
+	caBundleKey          = "ca_bundle"
+	clientCertificateKey = "client_certificate"
+	clientKeyKey         = "client_key"
+
+	// EndpointEnvName selects another UII API server, for example an on-prem mirror
+	EndpointEnvName = "VIRTOMIZE_API_URL"
+
+	// defaultEndpoint is the public Virtomize UII API
+	defaultEndpoint = "https://api.virtomize.com/uii"
+)
+
+var ErrInvalidEndpointConfig = errors.New("invalid uii endpoint configuration")
+
+// EndpointConfig selects the UII API server and how to connect to it. The files are PEM encoded.
+type EndpointConfig struct {
+	URL string
+	// CABundle is the path of the certificates trusted in addition to the system certificates
+	CABundle string
+	// ClientCertificate and ClientKey are the paths of the certificate presented to servers requiring mutual TLS
+	ClientCertificate string
+	ClientKey         string
+	// ProxyURL is the HTTP proxy for all requests, the proxy environment variables apply if it is empty
+	ProxyURL string
+}
+
+// uiiHTTPClient is an IUiiClient talking to a configurable UII API server. It speaks the same protocol as the client
+// of uii-go-api, which always targets the public service.
+type uiiHTTPClient struct {
+	token      string
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+// newUiiHTTPClient creates a client for the server selected by the config
+func newUiiHTTPClient(token string, config EndpointConfig) (*uiiHTTPClient, error) {
+	endpoint := config.URL
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s %q is not an absolute http or https URL", ErrInvalidEndpointConfig, endpointKey, endpoint)
+	}
+
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uiiHTTPClient{
+		token:      token,
+		endpoint:   u,
+		httpClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+// newTransport creates the transport with the TLS and proxy settings of the config
+func newTransport(config EndpointConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("%w: %s %q is not an absolute URL", ErrInvalidEndpointConfig, proxyURLKey, config.ProxyURL)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			//nolint: errorlint // can't have two errors
+			return nil, fmt.Errorf("%w: could not read %s: %s", ErrInvalidEndpointConfig, caBundleKey, err.Error())
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s %s contains no PEM encoded certificates", ErrInvalidEndpointConfig, caBundleKey, config.CABundle)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if (config.ClientCertificate == "") != (config.ClientKey == "") {
+		return nil, fmt.Errorf("%w: %s and %s must be set together", ErrInvalidEndpointConfig, clientCertificateKey, clientKeyKey)
+	}
+
+	if config.ClientCertificate != "" {
+		certificate, err := tls.LoadX509KeyPair(config.ClientCertificate, config.ClientKey)
+		if err != nil {
+			//nolint: errorlint // can't have two errors
+			return nil, fmt.Errorf("%w: could not load the client certificate: %s", ErrInvalidEndpointConfig, err.Error())
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// buildRequestBody is the body of a build request, the arguments and options are flattened into one object
+type buildRequestBody struct {
+	client.BuildArgs
+	client.BuildOpts
+}
+
+// Build requests the ISO and writes it to filePath. A partial file is removed if the download fails or the context is
+// canceled.
+func (c *uiiHTTPClient) Build(ctx context.Context, filePath string, args client.BuildArgs, opts client.BuildOpts) error {
+	body, err := json.Marshal(buildRequestBody{args, opts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "images", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the ISO contains the password hash, keys and network configuration of the host
+	file, err := os.OpenFile(filepath.Clean(filePath), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(filePath)
+		return &os.PathError{Op: "write", Path: filePath, Err: err}
+	}
+
+	return nil
+}
+
+// OperatingSystems lists the operating systems UII can build
+func (c *uiiHTTPClient) OperatingSystems(ctx context.Context) ([]client.OS, error) {
+	resp, err := c.do(ctx, http.MethodGet, "oslist", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Embedded []client.OS `json:"_embedded"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("could not parse the operating systems: %w", err)
+	}
+
+	return response.Embedded, nil
+}
+
+// do sends an authenticated request to the path below the endpoint. Responses other than 200 are returned as errors.
+func (c *uiiHTTPClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", client.DefaultUserAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, parseUiiError(resp)
+	}
+
+	return resp, nil
+}
+
+// uiiStatusError is a response of the UII API other than 200
+type uiiStatusError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the Retry-After header, zero if there is none
+	RetryAfter time.Duration
+	message    string
+	err        error
+}
+
+func (e *uiiStatusError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.err.Error())
+	}
+
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.message)
+}
+
+// Unwrap returns the client.UIIError of the response, if it contained one
+func (e *uiiStatusError) Unwrap() error {
+	return e.err
+}
+
+// parseUiiError returns the error of the UII response, or the start of the body if it is no UII error
+func parseUiiError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	statusErr := &uiiStatusError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+
+	var uiiErr client.UIIError
+	if err := json.Unmarshal(body, &uiiErr); err == nil && len(uiiErr.Errors) > 0 {
+		statusErr.err = uiiErr
+		return statusErr
+	}
+
+	statusErr.message = strings.TrimSpace(string(body))
+	if len(statusErr.message) > 200 {
+		statusErr.message = statusErr.message[:200] + "..."
+	}
+
+	return statusErr
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,231 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/Virtomize/uii-go-api"
+	"github.com/stretchr/testify/assert"
+)
+
+const standInToken = "stand-in-token"
+
+// uiiStandIn is a stand-in of the UII API below /uii, it builds ISOs containing the host name
+type uiiStandIn struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	builds   []buildRequestBody
+}
+
+func (s *uiiStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+standInToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(client.UIIError{Errors: []string{"invalid token"}, StatusCode: http.StatusUnauthorized})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/uii/oslist":
+		_, _ = w.Write([]byte(`{"_embedded":[{"dist":"debian","version":"11","arch":"x86_64","displayname":"Debian 11"}]}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/uii/images":
+		var body buildRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.builds = append(s.builds, body)
+		s.mu.Unlock()
+		_, _ = w.Write([]byte("iso for " + body.Hostname))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *uiiStandIn) lastRequest() *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+func newUiiStandIn(t *testing.T) (*uiiStandIn, *httptest.Server) {
+	standIn := &uiiStandIn{}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return standIn, server
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to the folder
+func writeTestCertificate(t *testing.T, folder, name string) (certFile, keyFile string, certificate *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	certificate, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile = path.Join(folder, name+".crt")
+	keyFile = path.Join(folder, name+".key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile, certificate
+}
+
+func TestUiiClientAgainstStandIn(t *testing.T) {
+	standIn, server := newUiiStandIn(t)
+
+	c, err := newUiiHTTPClient(standInToken, EndpointConfig{URL: server.URL + "/uii/"})
+	assert.NoError(t, err)
+
+	operatingSystems, err := c.OperatingSystems(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []client.OS{{Architecture: "x86_64", DisplayName: "Debian 11", Distribution: "debian", Version: "11"}}, operatingSystems)
+
+	filePath := path.Join(t.TempDir(), "debian.iso")
+	args := client.BuildArgs{Distribution: "debian", Version: "11", Hostname: "examplehost", Networks: []client.NetworkArgs{{DHCP: true}}}
+	opts := client.BuildOpts{Packages: []string{"vim"}}
+	assert.NoError(t, c.Build(context.Background(), filePath, args, opts))
+	assert.Equal(t, []byte("iso for examplehost"), readFile(t, filePath))
+	info, err := os.Stat(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the arguments and options are flattened into one object, like uii-go-api does
+	assert.Equal(t, []buildRequestBody{{args, opts}}, standIn.builds)
+	request := standIn.lastRequest()
+	assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+	assert.Equal(t, client.DefaultUserAgent, request.Header.Get("User-Agent"))
+
+	// errors of the API are returned
+	c, err = newUiiHTTPClient("wrong-token", EndpointConfig{URL: server.URL + "/uii"})
+	assert.NoError(t, err)
+	deniedPath := path.Join(t.TempDir(), "denied.iso")
+	err = c.Build(context.Background(), deniedPath, args, opts)
+	assert.ErrorAs(t, err, &client.UIIError{})
+	assert.Contains(t, err.Error(), "401")
+	assert.NoFileExists(t, deniedPath)
+}
+
+func TestUiiClientRemovesCanceledDownload(t *testing.T) {
+	// the stand-in sends a part of the ISO and stalls
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("iso f"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := newUiiHTTPClient(standInToken, EndpointConfig{URL: server.URL + "/uii"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	filePath := path.Join(t.TempDir(), "debian.iso")
+	err = c.Build(ctx, filePath, client.BuildArgs{Hostname: "examplehost"}, client.BuildOpts{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoFileExists(t, filePath)
+}
+
+func TestUiiClientWithCustomCAAndClientCertificate(t *testing.T) {
+	folder := t.TempDir()
+	certFile, keyFile, clientCertificate := writeTestCertificate(t, folder, "client")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCertificate)
+
+	server := httptest.NewUnstartedServer(&uiiStandIn{})
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caBundle := path.Join(folder, "ca.pem")
+	assert.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	config := EndpointConfig{URL: server.URL + "/uii", CABundle: caBundle, ClientCertificate: certFile, ClientKey: keyFile}
+	c, err := newUiiHTTPClient(standInToken, config)
+	assert.NoError(t, err)
+	_, err = c.OperatingSystems(context.Background())
+	assert.NoError(t, err)
+
+	// the server is not trusted without the CA bundle
+	withoutCA := config
+	withoutCA.CABundle = ""
+	c, err = newUiiHTTPClient(standInToken, withoutCA)
+	assert.NoError(t, err)
+	_, err = c.OperatingSystems(context.Background())
+	assert.Error(t, err)
+
+	// the server requires the client certificate
+	withoutCertificate := config
+	withoutCertificate.ClientCertificate = ""
+	withoutCertificate.ClientKey = ""
+	c, err = newUiiHTTPClient(standInToken, withoutCertificate)
+	assert.NoError(t, err)
+	_, err = c.OperatingSystems(context.Background())
+	assert.Error(t, err)
+}
+
+func TestUiiClientUsesProxy(t *testing.T) {
+	standIn, proxy := newUiiStandIn(t)
+
+	// the endpoint can only be reached through the proxy
+	c, err := newUiiHTTPClient(standInToken, EndpointConfig{URL: "http://uii.invalid/uii", ProxyURL: proxy.URL})
+	assert.NoError(t, err)
+
+	_, err = c.OperatingSystems(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "uii.invalid", standIn.lastRequest().Host)
+}
+
+func TestEndpointConfigValidation(t *testing.T) {
+	folder := t.TempDir()
+	certFile, keyFile, _ := writeTestCertificate(t, folder, "client")
+
+	_, err := newUiiHTTPClient(standInToken, EndpointConfig{})
+	assert.NoError(t, err)
+
+	for _, config := range []EndpointConfig{
+		{URL: "api.example.com/uii"},
+		{URL: "ftp://api.example.com/uii"},
+		{ProxyURL: "proxy.example.com"},
+		{CABundle: path.Join(folder, "missing.pem")},
+		{CABundle: keyFile},
+		{ClientCertificate: certFile},
+		{ClientCertificate: keyFile, ClientKey: certFile},
+	} {
+		_, err := newUiiHTTPClient(standInToken, config)
+		assert.ErrorIs(t, err, ErrInvalidEndpointConfig, config)
+	}
+}
@@ -16,6 +16,7 @@ import (
 var (
 	ErrDistributionRequired        = errors.New("supported distribution required")
 	ErrDistributionVersionRequired = errors.New("supported distribution version required")
+	ErrArchitectureRequired        = errors.New("supported architecture or empty string required")
 	ErrInvalidHostname             = errors.New("valid hostname required, allowed characters are -, a-z, and 0-9")
 	ErrTimeZoneRequired            = errors.New("time zone or empty string required")
 	ErrLocaleRequired              = errors.New("valid BCP 47 locale or empty string required, e.g: (\"en-GB\")")
@@ -30,6 +31,15 @@ var (
 	ErrStaticNetworkIsMulticast    = errors.New("static network configuration error: configured CIDR is multi cast address, use different IP")
 	ErrMissingMac                  = errors.New("missing MAC address needed for multi network configuration")
 	ErrParsingMac                  = errors.New("parsing MAC address resulted in error")
+	ErrInvalidMissingFilePolicy    = errors.New("supported missing file policy or empty string required")
+	ErrInvalidRebuildPolicy        = errors.New("supported rebuild policy or empty string required")
+	ErrInvalidDuration             = errors.New("positive duration or empty string required, e.g: (\"48h\")")
+	ErrInvalidStorageBackend       = errors.New("supported storage backend or empty string required")
+	ErrInvalidPresignedURLLifetime = errors.New("presigned urls can be valid for at most 7 days")
+	ErrInvalidCacheSize            = errors.New("positive size or empty string required, e.g: (\"20GiB\")")
+	ErrInvalidVerifyChecksums      = errors.New("supported checksum verification or empty string required")
+	ErrInvalidRetryMaxAttempts     = errors.New("at least one attempt required")
+	ErrInvalidLimit                = errors.New("positive number or null required")
 )
 
 func validateIso(plan isoResourceModel, distributions []client.OS) []error {
@@ -66,9 +76,29 @@ func validateIso(plan isoResourceModel, distributions []client.OS) []error {
 		result = append(result, localeErr)
 	}
 
+	missingFilePolicy := stringOrDefault(plan.OnMissingFile, "")
+	missingFileErr := validateMissingFilePolicy(missingFilePolicy)
+	if missingFileErr != nil {
+		result = append(result, missingFileErr)
+	}
+
+	rebuildAfter := stringOrDefault(plan.RebuildAfter, "")
+	rebuildAfterErr := validateDuration(rebuildAfter, rebuildAfterKey)
+	if rebuildAfterErr != nil {
+		result = append(result, rebuildAfterErr)
+	}
+
+	rebuildPolicy := stringOrDefault(plan.RebuildPolicy, "")
+	rebuildPolicyErr := validateRebuildPolicy(rebuildPolicy)
+	if rebuildPolicyErr != nil {
+		result = append(result, rebuildPolicyErr)
+	}
+
+	result = append(result, validateTimeouts(plan.Timeouts)...)
+
 	timezone := stringOrDefault(plan.Timezone, "")
 	timeErr := validateTimezone(timezone)
-	if localeErr != nil {
+	if timeErr != nil {
 		result = append(result, timeErr)
 	}
 
@@ -258,6 +288,157 @@ func validateTimezone(timeZone string) error {
 	return nil
 }
 
+func validateMissingFilePolicy(policy string) error {
+	switch policy {
+	case "", unknownString, missingFileRebuild, missingFileRecreate:
+		return nil
+	}
+
+	return fmt.Errorf("%w for %s, supported are: %s, %s; current value: %s",
+		ErrInvalidMissingFilePolicy,
+		onMissingFileKey,
+		missingFileRebuild,
+		missingFileRecreate,
+		policy)
+}
+
+func validateRebuildPolicy(policy string) error {
+	switch policy {
+	case "", unknownString, rebuildOnRead, rebuildOnApply, rebuildNever:
+		return nil
+	}
+
+	return fmt.Errorf("%w for %s, supported are: %s, %s, %s; current value: %s",
+		ErrInvalidRebuildPolicy,
+		rebuildPolicyKey,
+		rebuildOnRead,
+		rebuildOnApply,
+		rebuildNever,
+		policy)
+}
+
+func validateVerifyChecksums(mode string) error {
+	switch mode {
+	case "", unknownString, verifyChecksumsFull, verifyChecksumsQuick, verifyChecksumsOff:
+		return nil
+	}
+
+	return fmt.Errorf("%w for %s, supported are: %s, %s, %s; current value: %s",
+		ErrInvalidVerifyChecksums,
+		verifyChecksumsKey,
+		verifyChecksumsFull,
+		verifyChecksumsQuick,
+		verifyChecksumsOff,
+		mode)
+}
+
+func validateRetryMaxAttempts(attempts types.Int64) error {
+	if attempts.IsNull() || attempts.IsUnknown() || attempts.ValueInt64() >= 1 {
+		return nil
+	}
+
+	return fmt.Errorf("%w for %s, current value: %d",
+		ErrInvalidRetryMaxAttempts,
+		retryMaxAttemptsKey,
+		attempts.ValueInt64())
+}
+
+func validateLimit(limit types.Int64, key string) error {
+	if limit.IsNull() || limit.IsUnknown() || limit.ValueInt64() >= 1 {
+		return nil
+	}
+
+	return fmt.Errorf("%w for %s, current value: %d",
+		ErrInvalidLimit,
+		key,
+		limit.ValueInt64())
+}
+
+func validateStorageBackend(backend string) error {
+	switch backend {
+	case "", unknownString, storageBackendBolt, storageBackendDirectory:
+		return nil
+	}
+
+	return fmt.Errorf("%w for %s, supported are: %s, %s; current value: %s",
+		ErrInvalidStorageBackend,
+		storageBackendKey,
+		storageBackendBolt,
+		storageBackendDirectory,
+		backend)
+}
+
+func validatePresignedURLLifetime(lifetime string) error {
+	if err := validateDuration(lifetime, presignedURLLifetimeKey); err != nil {
+		return err
+	}
+
+	if lifetime == "" || lifetime == unknownString {
+		return nil
+	}
+
+	parsed, _ := time.ParseDuration(lifetime)
+	if parsed > maxPresignedURLLifetime {
+		return fmt.Errorf("%w for %s, current value: %s", ErrInvalidPresignedURLLifetime, presignedURLLifetimeKey, lifetime)
+	}
+
+	return nil
+}
+
+func validateCacheSize(size string) error {
+	if size == "" || size == unknownString {
+		return nil
+	}
+
+	parsed, err := parseByteSize(size)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w for %s, error: %s current value: %s",
+			ErrInvalidCacheSize,
+			maxCacheSizeKey,
+			err.Error(),
+			size)
+	}
+
+	if parsed <= 0 {
+		return fmt.Errorf("%w for %s, current value: %s",
+			ErrInvalidCacheSize,
+			maxCacheSizeKey,
+			size)
+	}
+
+	return nil
+}
+
+func validateDuration(duration string, key string) error {
+	if duration == "" {
+		return nil
+	}
+
+	if duration == unknownString {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(duration)
+	if err != nil {
+		//nolint: errorlint // can't have two errors
+		return fmt.Errorf("%w for %s, error: %s current value: %s",
+			ErrInvalidDuration,
+			key,
+			err.Error(),
+			duration)
+	}
+
+	if parsed <= 0 {
+		return fmt.Errorf("%w for %s, current value: %s",
+			ErrInvalidDuration,
+			key,
+			duration)
+	}
+
+	return nil
+}
+
 func validateHostname(hostname string) error {
 	reg := regexp.MustCompile(`^([a-zA-Z0-9])+([a-zA-Z0-9\\-])*$`)
 	match := reg.MatchString(hostname)
@@ -288,10 +469,11 @@ func validateDistribution(distribution, version, architecture string, distributi
 	}
 
 	if !foundDistribution {
-		return fmt.Errorf("%w for %s, supported are: %s; current value: %s", ErrDistributionRequired, distributionKey, strings.Join(displayNames, ", "), architecture)
+		return fmt.Errorf("%w for %s, supported are: %s; current value: %s", ErrDistributionRequired, distributionKey, strings.Join(displayNames, ", "), distribution)
 	}
 
 	foundVersion := false
+	displayNames = nil
 	for _, d := range distributions {
 		if d.Distribution == distribution {
 			displayNames = append(displayNames, d.DisplayName)
@@ -311,11 +493,22 @@ func validateDistribution(distribution, version, architecture string, distributi
 		return nil
 	}
 
+	var architectures []string
+	listed := map[string]bool{}
 	for _, d := range distributions {
-		if d.Distribution == distribution && d.Version == version && d.Architecture == architecture {
+		if d.Distribution != distribution || d.Version != version {
+			continue
+		}
+
+		if d.Architecture == architecture {
 			return nil
 		}
+
+		if !listed[d.Architecture] {
+			listed[d.Architecture] = true
+			architectures = append(architectures, d.Architecture)
+		}
 	}
 
-	return fmt.Errorf("%w for %s, supported are: %s; current value: %s", ErrDistributionRequired, distributionKey, strings.Join(displayNames, ", "), architecture)
+	return fmt.Errorf("%w for %s, supported are: %s; current value: %s", ErrArchitectureRequired, architectureKey, strings.Join(architectures, ", "), architecture)
 }
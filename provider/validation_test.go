@@ -2,6 +2,7 @@ package provider
 
 import (
 	client "github.com/Virtomize/uii-go-api"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -27,4 +28,104 @@ func TestDistributionValidation(t *testing.T) {
 	assert.Error(t, validateDistribution("debian", "10", "64", []client.OS{debian11}))
 	assert.Error(t, validateDistribution("debian", "11", "64", []client.OS{debian10}))
 	assert.Error(t, validateDistribution("debian", "10", "8", []client.OS{debian10}))
+
+	// the errors name the rejected value and the supported operating systems
+	err := validateDistribution("gentoo", "1", "64", []client.OS{debian10, debian11})
+	assert.ErrorIs(t, err, ErrDistributionRequired)
+	assert.Contains(t, err.Error(), "supported are: Debian 10 x64, Debian 11 x64; current value: gentoo")
+
+	err = validateDistribution("debian", "12", "64", []client.OS{debian10, debian11})
+	assert.ErrorIs(t, err, ErrDistributionVersionRequired)
+	assert.Contains(t, err.Error(), "supported are: Debian 10 x64, Debian 11 x64; current value: 12")
+
+	debian10arm := client.OS{Architecture: "arm64", DisplayName: "Debian 10 arm64", Distribution: "debian", Version: "10"}
+	err = validateDistribution("debian", "10", "32", []client.OS{debian10, debian11, debian10arm})
+	assert.ErrorIs(t, err, ErrArchitectureRequired)
+	assert.Contains(t, err.Error(), "for architecture, supported are: 64, arm64; current value: 32")
+}
+
+func TestMissingFilePolicyValidation(t *testing.T) {
+	assert.NoError(t, validateMissingFilePolicy(""))
+	assert.NoError(t, validateMissingFilePolicy(missingFileRebuild))
+	assert.NoError(t, validateMissingFilePolicy(missingFileRecreate))
+
+	assert.ErrorIs(t, validateMissingFilePolicy("ignore"), ErrInvalidMissingFilePolicy)
+}
+
+func TestRebuildSettingsValidation(t *testing.T) {
+	assert.NoError(t, validateRebuildPolicy(""))
+	assert.NoError(t, validateRebuildPolicy(rebuildOnRead))
+	assert.NoError(t, validateRebuildPolicy(rebuildOnApply))
+	assert.NoError(t, validateRebuildPolicy(rebuildNever))
+	assert.ErrorIs(t, validateRebuildPolicy("always"), ErrInvalidRebuildPolicy)
+
+	assert.NoError(t, validateDuration("", rebuildAfterKey))
+	assert.NoError(t, validateDuration("48h", rebuildAfterKey))
+	assert.NoError(t, validateDuration("1h30m", rebuildAfterKey))
+	assert.ErrorIs(t, validateDuration("2 days", rebuildAfterKey), ErrInvalidDuration)
+	assert.ErrorIs(t, validateDuration("0s", rebuildAfterKey), ErrInvalidDuration)
+	assert.ErrorIs(t, validateDuration("-1h", rebuildAfterKey), ErrInvalidDuration)
+}
+
+func TestStorageBackendValidation(t *testing.T) {
+	assert.NoError(t, validateStorageBackend(""))
+	assert.NoError(t, validateStorageBackend(storageBackendBolt))
+	assert.NoError(t, validateStorageBackend(storageBackendDirectory))
+	assert.ErrorIs(t, validateStorageBackend("sqlite"), ErrInvalidStorageBackend)
+}
+
+func TestVerifyChecksumsValidation(t *testing.T) {
+	for _, mode := range []string{"", unknownString, verifyChecksumsFull, verifyChecksumsQuick, verifyChecksumsOff} {
+		assert.NoError(t, validateVerifyChecksums(mode))
+	}
+
+	assert.ErrorIs(t, validateVerifyChecksums("sometimes"), ErrInvalidVerifyChecksums)
+}
+
+func TestRetrySettingsValidation(t *testing.T) {
+	assert.NoError(t, validateRetryMaxAttempts(types.Int64Null()))
+	assert.NoError(t, validateRetryMaxAttempts(types.Int64Unknown()))
+	assert.NoError(t, validateRetryMaxAttempts(types.Int64Value(1)))
+	assert.ErrorIs(t, validateRetryMaxAttempts(types.Int64Value(0)), ErrInvalidRetryMaxAttempts)
+	assert.NoError(t, validateDuration("1m", retryMaxBackoffKey))
+	assert.ErrorIs(t, validateDuration("0s", retryMaxBackoffKey), ErrInvalidDuration)
+}
+
+func TestRequestLimitValidation(t *testing.T) {
+	assert.NoError(t, validateLimit(types.Int64Null(), maxConcurrentBuildsKey))
+	assert.NoError(t, validateLimit(types.Int64Unknown(), maxConcurrentBuildsKey))
+	assert.NoError(t, validateLimit(types.Int64Value(2), maxConcurrentBuildsKey))
+	assert.ErrorIs(t, validateLimit(types.Int64Value(0), requestsPerMinuteKey), ErrInvalidLimit)
+	assert.ErrorIs(t, validateLimit(types.Int64Value(-5), requestsPerMinuteKey), ErrInvalidLimit)
+}
+
+func TestCacheLimitValidation(t *testing.T) {
+	assert.NoError(t, validateCacheSize(""))
+	assert.NoError(t, validateCacheSize("20GiB"))
+	assert.NoError(t, validateCacheSize("1.5 GB"))
+	assert.NoError(t, validateCacheSize("1048576"))
+	assert.ErrorIs(t, validateCacheSize("0MB"), ErrInvalidCacheSize)
+	assert.ErrorIs(t, validateCacheSize("-1GB"), ErrInvalidCacheSize)
+	assert.ErrorIs(t, validateCacheSize("20 gigabytes"), ErrInvalidCacheSize)
+	assert.ErrorIs(t, validateCacheSize("99999999TiB"), ErrInvalidCacheSize)
+
+	size, err := parseByteSize("1.5GiB")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1536*1024*1024), size)
+
+	size, err = parseByteSize("500MB")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500*1000*1000), size)
+}
+
+func TestTimezoneValidation(t *testing.T) {
+	assert.NoError(t, validateTimezone(""))
+	assert.NoError(t, validateTimezone("Europe/Berlin"))
+
+	// an invalid time zone is reported although the locale is valid
+	model := fullIsoModel()
+	model.Timezone = types.StringValue("Mars/Olympus")
+	errs := validateIso(model, nil)
+	assert.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], ErrTimeZoneRequired)
 }